@@ -4,6 +4,7 @@ import (
 	"sync"
 
 	"github.com/cresta/magehelper/env"
+	"github.com/cresta/magehelper/pipe"
 )
 
 type registry struct {
@@ -31,6 +32,9 @@ func Instance() CiCd {
 	if err != nil {
 		panic(err)
 	}
+	if m, ok := globalInstance.(Masker); ok {
+		pipe.OnSecret(m.AddMask)
+	}
 	return globalInstance
 }
 
@@ -64,6 +68,33 @@ type CiCd interface {
 	AddStepOutput(key string, value string)
 }
 
+// PullRequest is implemented by CI backends that know which branch the pull request being built targets
+type PullRequest interface {
+	// PullRequestBase returns the target branch of the pull request, or "" when not building a pull request
+	PullRequestBase() string
+}
+
+// Annotation is an error about a build, like a failing test, that a CI backend can show next to the code
+type Annotation struct {
+	// File is the path of the file relative to the repository root.  Optional
+	File string
+	// Line is the line in File.  Optional
+	Line    int
+	Title   string
+	Message string
+}
+
+// Annotator is implemented by CI backends that can surface errors in their UI
+type Annotator interface {
+	AnnotateError(annotation Annotation)
+}
+
+// Masker is implemented by CI backends that can hide secrets in their logs.  Instance registers it for every secret
+// pipe redacts, including secret environment variables set later, which are masked before the next command runs
+type Masker interface {
+	AddMask(value string)
+}
+
 type Local struct {
 	Env *env.Env
 }
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strconv"
 
 	"github.com/cresta/magehelper/cicd"
 	"github.com/cresta/magehelper/env"
@@ -41,6 +42,9 @@ func isGithubActions(env *env.Env) bool {
 }
 
 var _ cicd.CiCd = &GithubActions{}
+var _ cicd.PullRequest = &GithubActions{}
+var _ cicd.Annotator = &GithubActions{}
+var _ cicd.Masker = &GithubActions{}
 
 func (g *GithubActions) IncrementalID() string {
 	return g.Env.Get("GITHUB_RUN_NUMBER")
@@ -54,10 +58,32 @@ func (g *GithubActions) GitRef() string {
 	return g.Env.Get("GITHUB_REF")
 }
 
+func (g *GithubActions) PullRequestBase() string {
+	return g.Env.Get("GITHUB_BASE_REF")
+}
+
 func (g *GithubActions) AddStepOutput(key string, value string) {
 	g.Actions.SetOutput(key, value)
 }
 
+func (g *GithubActions) AnnotateError(annotation cicd.Annotation) {
+	fields := make(map[string]string)
+	if annotation.File != "" {
+		fields["file"] = annotation.File
+		if annotation.Line > 0 {
+			fields["line"] = strconv.Itoa(annotation.Line)
+		}
+	}
+	if annotation.Title != "" {
+		fields["title"] = annotation.Title
+	}
+	g.Actions.WithFieldsMap(fields).Errorf("%s", annotation.Message)
+}
+
+func (g *GithubActions) AddMask(value string) {
+	g.Actions.AddMask(value)
+}
+
 func (g *GithubActions) Name() string {
 	return "gh"
 }
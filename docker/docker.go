@@ -15,6 +15,7 @@ import (
 	"github.com/cresta/magehelper/files"
 	"github.com/cresta/magehelper/git"
 	"github.com/cresta/magehelper/pipe"
+	"github.com/cresta/magehelper/tools"
 	gogit "github.com/go-git/go-git/v5"
 )
 
@@ -36,6 +37,8 @@ type Docker struct {
 	CiCd            cicd.CiCd
 	Git             *git.Git
 	IgnoreFastBuild bool
+	// PushRetry is how Push retries transient failures.  Defaults to pipe.NetworkRetry
+	PushRetry *pipe.RetryPolicy
 }
 
 func (d *Docker) registry() registry.Registry {
@@ -62,6 +65,13 @@ func (d *Docker) cicd() cicd.CiCd {
 	return d.CiCd
 }
 
+func (d *Docker) pushRetry() pipe.RetryPolicy {
+	if d.PushRetry == nil {
+		return pipe.NetworkRetry
+	}
+	return *d.PushRetry
+}
+
 func (d *Docker) git() *git.Git {
 	if d.Git == nil {
 		return &git.Instance
@@ -268,6 +278,15 @@ func (d *Docker) BuildxCacheTo() string {
 	return d.Env.GetDefault("DOCKER_BUILDX_TO", "/tmp/.buildx-cache-new")
 }
 
+// expandSecret returns the value of the environment variable name, and registers it to be redacted if it is a secret
+func (d *Docker) expandSecret(name string) string {
+	value := d.Env.Get(name)
+	if pipe.IsSecretEnv(name) {
+		pipe.AddSecret(value)
+	}
+	return value
+}
+
 func isTrue(s string) bool {
 	res, err := strconv.ParseBool(s)
 	return res && err == nil
@@ -303,7 +322,7 @@ type BuildConfig struct {
 }
 
 func (d *Docker) ImageExists(ctx context.Context, tag string) bool {
-	err := pipe.Shell("docker inspect --type=image "+tag).Execute(ctx, nil, nil, nil)
+	err := pipe.Shell("docker inspect --type=image "+tag).ReadOnly().Execute(ctx, nil, nil, nil)
 	return err == nil
 }
 
@@ -323,6 +342,9 @@ func (d *Docker) BuildWithConfig(ctx context.Context, config BuildConfig) error
 		args = append(args, "--load")
 	}
 	for _, a := range config.BuildArgs {
+		if name, value, found := strings.Cut(a, "="); found && pipe.IsSecretEnv(name) {
+			pipe.AddSecret(value)
+		}
 		args = append(args, "--build-arg", a)
 	}
 	for _, mutableTag := range d.mutableBuildTags() {
@@ -349,7 +371,7 @@ func (d *Docker) BuildWithConfig(ctx context.Context, config BuildConfig) error
 		if strings.TrimSpace(extraBuildArg) == "" {
 			continue
 		}
-		args = append(args, os.Expand(strings.TrimSpace(extraBuildArg), d.Env.Get))
+		args = append(args, os.Expand(strings.TrimSpace(extraBuildArg), d.expandSecret))
 	}
 	if pushLocalCache {
 		// Use local cache
@@ -370,7 +392,7 @@ func (d *Docker) Push(ctx context.Context) error {
 		tags = append(tags, d.ImageWithTag(mutableTag))
 	}
 	for _, tag := range tags {
-		if err := pipe.NewPiped("docker", "push", tag).Run(ctx); err != nil {
+		if err := pipe.NewPiped("docker", "push", tag).WithRetry(d.pushRetry()).Run(ctx); err != nil {
 			return fmt.Errorf("unable to push tag %s: %w", tag, err)
 		}
 	}
@@ -378,7 +400,7 @@ func (d *Docker) Push(ctx context.Context) error {
 }
 
 func (d *Docker) Lint(ctx context.Context) error {
-	allDocker, err := files.AllWithExtension("Dockerfile")
+	allDocker, err := files.Find(".").GitIgnore().Extensions("Dockerfile").ChangedIfRequested(&d.Env).All(ctx)
 	if err != nil {
 		return err
 	}
@@ -398,7 +420,7 @@ func (d *Docker) Lint(ctx context.Context) error {
 			if err != nil {
 				return fmt.Errorf("uanble to open dockerfile for reading: %w", err)
 			}
-			if err := pipe.NewPiped("docker", `run`, `-i`, `--rm`, `hadolint/hadolint`).Execute(ctx, f, os.Stdout, os.Stderr); err != nil {
+			if err := pipe.NewPiped("docker", `run`, `-i`, `--rm`, tools.Instance.Image("hadolint", "hadolint/hadolint")).Execute(ctx, f, os.Stdout, os.Stderr); err != nil {
 				hadoErr = err
 			}
 			return nil
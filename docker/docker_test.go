@@ -1,10 +1,15 @@
 package docker
 
 import (
+	"context"
+	"path/filepath"
 	"testing"
 
 	"github.com/cresta/magehelper/cicd/githubactions"
+	"github.com/cresta/magehelper/docker/registry"
 	"github.com/cresta/magehelper/env"
+	"github.com/cresta/magehelper/pipe"
+	actions "github.com/sethvargo/go-githubactions"
 	"github.com/stretchr/testify/require"
 )
 
@@ -23,3 +28,34 @@ func TestDocker_Tag(t *testing.T) {
 	}
 	require.Equal(t, "hotfix_fix-wrong-sha-gh.123-deadbea", d.Tag())
 }
+
+func TestDocker_BuildWithConfig(t *testing.T) {
+	e := env.NewFromMap(map[string]string{
+		"GITHUB_REF":           "refs/heads/feature",
+		"GITHUB_RUN_NUMBER":    "123",
+		"GITHUB_SHA":           "deadbeaf",
+		"DOCKER_REPOSITORY":    "cresta/app",
+		"DOCKER_PUSH":          "true",
+		"DOCKER_FILE":          "Dockerfile.app",
+		"DOCKER_EXTRA_ARGS":    "--platform=linux/arm64 --label=run=$GITHUB_RUN_NUMBER",
+		"DOCKER_BUILDX_FROM":   "/nonexistent/from",
+		"DOCKER_BUILDX_TO":     "/nonexistent/to",
+		"DOCKER_LATEST_BRANCH": "main",
+		"GITHUB_OUTPUT":        filepath.Join(t.TempDir(), "output"),
+	})
+	d := Docker{
+		Env: *e,
+		CiCd: &githubactions.GithubActions{
+			Env:     e,
+			Actions: actions.New(actions.WithGetenv(e.Get)),
+		},
+		Registry: &registry.Local{},
+	}
+	rec := &pipe.Recording{}
+	defer pipe.SetRecorder(rec)()
+	require.NoError(t, d.BuildWithConfig(context.Background(), BuildConfig{BuildArgs: []string{"VERSION=1"}}))
+	require.Equal(t, []string{
+		"docker buildx build --push --build-arg VERSION=1 -f Dockerfile.app --cache-from=cresta/app:cache-cresta_app-feature " +
+			"--platform=linux/arm64 --label=run=123 -t cresta/app:feature-gh.123-deadbea .",
+	}, rec.Commands())
+}
@@ -27,7 +27,8 @@ func (d *DockerHub) ContainerRegistry() string {
 }
 
 func (d *DockerHub) Login(ctx context.Context) error {
-	return pipe.NewPiped("docker", "login", "--username", d.Username(), "--password-stdin", d.ContainerRegistry()).Execute(ctx, strings.NewReader(d.Password()), os.Stdout, os.Stderr)
+	pipe.AddSecret(d.Password())
+	return pipe.NewPiped("docker", "login", "--username", d.Username(), "--password-stdin", d.ContainerRegistry()).WithRetry(registry.LoginRetry).Execute(ctx, strings.NewReader(d.Password()), os.Stdout, os.Stderr)
 }
 
 // Login will log into dockerhub using password inside DOCKERHUB_PASSWORD
@@ -35,7 +35,8 @@ func (e *Ecr) ContainerRegistry() string {
 }
 
 func (e *Ecr) Login(ctx context.Context) error {
-	p := pipe.NewPiped("aws", "ecr", "get-login-password", "--region", e.defaultRegion()).Pipe("docker", "login", "--username=AWS", "--password-stdin", e.ContainerRegistry())
+	p := pipe.NewPiped("aws", "ecr", "get-login-password", "--region", e.defaultRegion()).Pipe("docker", "login", "--username=AWS", "--password-stdin", e.ContainerRegistry()).
+		WithRetry(registry.LoginRetry)
 	return p.Execute(ctx, nil, os.Stdout, os.Stderr)
 }
 
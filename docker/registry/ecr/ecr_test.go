@@ -0,0 +1,31 @@
+package ecr
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/cresta/magehelper/env"
+	"github.com/cresta/magehelper/pipe"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEcr_Login(t *testing.T) {
+	e := &Ecr{
+		Env: *env.NewFromMap(map[string]string{
+			"AWS_ACCOUNT_ID":     "123456789012",
+			"AWS_DEFAULT_REGION": "eu-west-1",
+		}),
+	}
+	rec := &pipe.Recording{}
+	defer pipe.SetRecorder(rec)()
+	require.NoError(t, e.Login(context.Background()))
+	require.Equal(t, []string{
+		"aws ecr get-login-password --region eu-west-1 | docker login --username=AWS --password-stdin 123456789012.dkr.ecr.eu-west-1.amazonaws.com",
+	}, rec.Commands())
+
+	rec.Stub = func(_ []pipe.Command, _ io.Reader, _ io.Writer) error {
+		return &pipe.ExitError{Args: []string{"docker", "login"}, ExitCode: 1}
+	}
+	require.Error(t, e.Login(context.Background()))
+}
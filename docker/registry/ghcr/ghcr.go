@@ -40,7 +40,8 @@ func (e *Ghcr) ContainerRegistry() string {
 }
 
 func (e *Ghcr) Login(ctx context.Context) error {
-	return pipe.NewPiped("docker", "login", "--username", e.Username(), "--password-stdin", e.ContainerRegistry()).Execute(ctx, strings.NewReader(e.Password()), os.Stdout, os.Stderr)
+	pipe.AddSecret(e.Password())
+	return pipe.NewPiped("docker", "login", "--username", e.Username(), "--password-stdin", e.ContainerRegistry()).WithRetry(registry.LoginRetry).Execute(ctx, strings.NewReader(e.Password()), os.Stdout, os.Stderr)
 }
 
 // Login will log into GHCR using password inside GHCR_PAT
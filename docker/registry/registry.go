@@ -1,12 +1,19 @@
 package registry
 
-import "context"
+import (
+	"context"
+
+	"github.com/cresta/magehelper/pipe"
+)
 
 type Registry interface {
 	ContainerRegistry() string
 	Login(ctx context.Context) error
 }
 
+// LoginRetry is how the Login of the registries in magehelper retries transient failures
+var LoginRetry = pipe.NetworkRetry
+
 // Instance is where you push docker images
 var Instance Registry
 
@@ -0,0 +1,76 @@
+package files
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strconv"
+
+	"github.com/cresta/magehelper/cicd"
+	"github.com/cresta/magehelper/env"
+	"github.com/cresta/magehelper/git"
+)
+
+// ChangedBase resolves the revision that Changed compares against.  If base is set, it is the merge base of HEAD and
+// base.  Otherwise, it is the merge base with the pull request target branch when building a pull request in CI, or
+// HEAD, so only uncommitted changes count.
+func ChangedBase(ctx context.Context, base string) (string, error) {
+	if base == "" {
+		if pr, ok := cicd.Instance().(cicd.PullRequest); ok && pr.PullRequestBase() != "" {
+			base = "origin/" + pr.PullRequestBase()
+		}
+	}
+	if base == "" {
+		return "HEAD", nil
+	}
+	return git.Instance.MergeBase(ctx, base)
+}
+
+// Changed returns files changed against base, relative to the current directory.  This includes committed, staged,
+// unstaged and untracked files.  Deleted files are not returned.  See ChangedBase for how base is resolved.
+func Changed(ctx context.Context, base string) ([]string, error) {
+	rev, err := ChangedBase(ctx, base)
+	if err != nil {
+		return nil, err
+	}
+	changed, err := git.Instance.DiffNames(ctx, rev)
+	if err != nil {
+		return nil, err
+	}
+	untracked, err := git.Instance.UntrackedFiles(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]string, 0, len(changed)+len(untracked))
+	for _, f := range append(changed, untracked...) {
+		ret = append(ret, filepath.FromSlash(f))
+	}
+	return ret, nil
+}
+
+// OnlyChanged reports if targets should only look at changed files, which is set with ${MAGEHELPER_ONLY_CHANGED}.  The
+// returned base is ${MAGEHELPER_CHANGED_BASE}, to pass to Changed.
+func OnlyChanged(e *env.Env) (string, bool) {
+	enabled, err := strconv.ParseBool(e.Get("MAGEHELPER_ONLY_CHANGED"))
+	if err != nil || !enabled {
+		return "", false
+	}
+	return e.Get("MAGEHELPER_CHANGED_BASE"), true
+}
+
+// changedAbs returns the absolute paths of the files from Changed
+func changedAbs(ctx context.Context, base string) (map[string]bool, error) {
+	changed, err := Changed(ctx, base)
+	if err != nil {
+		return nil, fmt.Errorf("unable to find changed files: %w", err)
+	}
+	ret := make(map[string]bool, len(changed))
+	for _, f := range changed {
+		abs, err := filepath.Abs(f)
+		if err != nil {
+			return nil, err
+		}
+		ret[abs] = true
+	}
+	return ret, nil
+}
@@ -0,0 +1,71 @@
+package files
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"strings"
+)
+
+type dockerIgnorePattern struct {
+	segments  []string
+	exception bool
+}
+
+// dockerIgnore applies the patterns of a .dockerignore file.  Patterns are anchored to the root, match a path if
+// they match it or any of its parent directories, and the last matching pattern wins.
+type dockerIgnore struct {
+	patterns      []dockerIgnorePattern
+	hasExceptions bool
+}
+
+func loadDockerIgnore(file string) *dockerIgnore {
+	ret := &dockerIgnore{}
+	f, err := os.Open(file)
+	if err != nil {
+		return ret
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p := dockerIgnorePattern{}
+		if strings.HasPrefix(line, "!") {
+			p.exception = true
+			ret.hasExceptions = true
+			line = strings.TrimSpace(line[1:])
+		}
+		line = strings.TrimPrefix(path.Clean("/"+line), "/")
+		if line == "" {
+			continue
+		}
+		p.segments = strings.Split(line, "/")
+		ret.patterns = append(ret.patterns, p)
+	}
+	return ret
+}
+
+func (d *dockerIgnore) ignored(name string) bool {
+	parts := strings.Split(name, "/")
+	ignored := false
+	for _, p := range d.patterns {
+		for i := len(parts); i > 0; i-- {
+			if matchSegments(p.segments, parts[:i]) {
+				ignored = !p.exception
+				break
+			}
+		}
+	}
+	return ignored
+}
+
+// skipDir reports if nothing inside the directory can be part of the context.  With exceptions, a file inside an
+// ignored directory may be added back, so the directory must still be walked.
+func (d *dockerIgnore) skipDir(name string) bool {
+	return !d.hasExceptions && d.ignored(name)
+}
@@ -1,6 +1,7 @@
 package files
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -49,22 +50,15 @@ func AllWithExtension(ext string) ([]string, error) {
 	return AllWithExtensionInDir(pathS, ext)
 }
 
+// AllWithExtensionInDir returns all file names under dir with the extension 'ext', relative to dir
 func AllWithExtensionInDir(dir string, ext string) ([]string, error) {
-	ext = strings.ToLower(ext)
-	var files []string
-	if err := filepath.Walk(dir, func(path string, f os.FileInfo, _ error) error {
-		if !f.IsDir() {
-			if hasExt(f, ext) {
-				if rel, err := filepath.Rel(dir, path); err == nil {
-					files = append(files, rel)
-				}
-			}
-		}
-		return nil
-	}); err != nil {
-		return nil, err
-	}
-	return files, nil
+	return Find(dir).ExcludeDirs().Symlinks(SymlinkList).Extensions(ext).All(context.Background())
+}
+
+// AllWithExtensionsInDir returns all file names under dir with any of the extensions, relative to dir.  It does not
+// descend into DefaultExcludedDirs and skips everything ignored by .gitignore files.
+func AllWithExtensionsInDir(dir string, exts ...string) ([]string, error) {
+	return Find(dir).GitIgnore().Extensions(exts...).All(context.Background())
 }
 
 type Nameable interface {
@@ -0,0 +1,45 @@
+package files
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeFiles(t *testing.T, root string, contents map[string]string) {
+	for name, content := range contents {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	}
+}
+
+func TestAllWithExtensionsInDir(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		".gitignore":                "generated/\n*.tmp.yaml\n",
+		"a.yaml":                    "",
+		"b.yml":                     "",
+		"c.json":                    "",
+		"x.tmp.yaml":                "",
+		"generated/chart.yaml":      "",
+		"vendor/mod/a.yaml":         "",
+		"node_modules/pkg/x.yml":    "",
+		"sub/.gitignore":            "skip.yaml\n",
+		"sub/skip.yaml":             "",
+		"sub/keep.YAML":             "",
+		"other/skip.yaml":           "",
+		"sub/deeper/generated.yaml": "",
+	})
+	found, err := AllWithExtensionsInDir(root, ".yaml", ".yml")
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{
+		"a.yaml",
+		"b.yml",
+		"other/skip.yaml",
+		filepath.FromSlash("sub/keep.YAML"),
+		filepath.FromSlash("sub/deeper/generated.yaml"),
+	}, found)
+}
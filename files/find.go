@@ -0,0 +1,372 @@
+package files
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/cresta/magehelper/env"
+	"github.com/cresta/magehelper/pipe"
+)
+
+// SymlinkPolicy controls how a Finder treats symbolic links
+type SymlinkPolicy int
+
+const (
+	// SymlinkSkip ignores symbolic links
+	SymlinkSkip SymlinkPolicy = iota
+	// SymlinkList returns symbolic links as files, without looking at what they point to
+	SymlinkList
+	// SymlinkFollow follows symbolic links to files and directories.  Each real directory is only walked once, so
+	// link loops terminate.
+	SymlinkFollow
+)
+
+// Result is a single file found by a Finder, or an error hit while walking
+type Result struct {
+	// Path is relative to the root of the Finder
+	Path string
+	Err  error
+}
+
+// Finder looks for files below a root directory.  Create one with Find and configure it with the builder methods.
+type Finder struct {
+	root         string
+	includes     []string
+	excludes     []string
+	extensions   []string
+	excludeDirs  []string
+	gitIgnore    bool
+	dockerIgnore bool
+	gitTracked   bool
+	maxDepth     int
+	symlinks     SymlinkPolicy
+	onlyChanged  bool
+	changedBase  string
+}
+
+// Find starts a Finder for the directory root.  By default, it returns every file except those inside
+// DefaultExcludedDirs and skips symbolic links.
+func Find(root string) *Finder {
+	return &Finder{
+		root:        root,
+		excludeDirs: DefaultExcludedDirs,
+	}
+}
+
+// Include only returns files matching at least one of the globs.  See Match for the glob syntax
+func (f *Finder) Include(globs ...string) *Finder {
+	f.includes = append(f.includes, globs...)
+	return f
+}
+
+// Exclude skips files and directories matching any of the globs.  See Match for the glob syntax
+func (f *Finder) Exclude(globs ...string) *Finder {
+	f.excludes = append(f.excludes, globs...)
+	return f
+}
+
+// Extensions only returns files with one of the extensions, compared case insensitively.  An extension also matches
+// a file with exactly that name, so "Dockerfile" finds Dockerfiles.
+func (f *Finder) Extensions(exts ...string) *Finder {
+	for _, ext := range exts {
+		f.extensions = append(f.extensions, strings.ToLower(ext))
+	}
+	return f
+}
+
+// ExcludeDirs replaces the directory names that are never descended into, DefaultExcludedDirs by default
+func (f *Finder) ExcludeDirs(names ...string) *Finder {
+	f.excludeDirs = names
+	return f
+}
+
+// GitIgnore skips files ignored by .gitignore files and .git/info/exclude
+func (f *Finder) GitIgnore() *Finder {
+	f.gitIgnore = true
+	return f
+}
+
+// DockerIgnore skips files excluded by the .dockerignore file in the root, as docker would for a build context
+func (f *Finder) DockerIgnore() *Finder {
+	f.dockerIgnore = true
+	return f
+}
+
+// GitTracked only returns files that are tracked by git
+func (f *Finder) GitTracked() *Finder {
+	f.gitTracked = true
+	return f
+}
+
+// Changed only returns files that changed against base.  See Changed for how base is resolved
+func (f *Finder) Changed(base string) *Finder {
+	f.onlyChanged = true
+	f.changedBase = base
+	return f
+}
+
+// ChangedIfRequested applies Changed when ${MAGEHELPER_ONLY_CHANGED} is set in e.  See OnlyChanged
+func (f *Finder) ChangedIfRequested(e *env.Env) *Finder {
+	if base, ok := OnlyChanged(e); ok {
+		return f.Changed(base)
+	}
+	return f
+}
+
+// MaxDepth limits how deep the Finder descends.  1 only returns files directly in the root.  0, the default, has no
+// limit.
+func (f *Finder) MaxDepth(depth int) *Finder {
+	f.maxDepth = depth
+	return f
+}
+
+// Symlinks sets how symbolic links are treated
+func (f *Finder) Symlinks(policy SymlinkPolicy) *Finder {
+	f.symlinks = policy
+	return f
+}
+
+// Stream walks the tree in the background, sending every file to the returned channel.  The channel is closed once
+// the walk is done or ctx is canceled.  Errors do not stop the walk: they are sent as a Result with Err set.
+func (f *Finder) Stream(ctx context.Context) <-chan Result {
+	ret := make(chan Result, 64)
+	go func() {
+		defer close(ret)
+		w, err := f.newWalk(ctx, ret)
+		if err != nil {
+			w.send(Result{Err: err})
+			return
+		}
+		w.walk(f.root, nil, w.ignore)
+	}()
+	return ret
+}
+
+// All returns every file found, relative to the root.  It fails on the first error.
+func (f *Finder) All(ctx context.Context) ([]string, error) {
+	var ret []string
+	var firstErr error
+	for r := range f.Stream(ctx) {
+		if r.Err != nil {
+			if firstErr == nil {
+				firstErr = r.Err
+			}
+			continue
+		}
+		ret = append(ret, r.Path)
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+type walk struct {
+	*Finder
+	ctx    context.Context
+	out    chan<- Result
+	ignore *gitIgnore
+	docker *dockerIgnore
+	// tracked holds every git tracked file and the directories containing them, when GitTracked is set
+	tracked map[string]bool
+	// changed holds the absolute path of every changed file, when Changed is set
+	changed map[string]bool
+	rootAbs string
+	visited map[string]bool
+}
+
+func (f *Finder) newWalk(ctx context.Context, out chan<- Result) (*walk, error) {
+	w := &walk{
+		Finder:  f,
+		ctx:     ctx,
+		out:     out,
+		visited: make(map[string]bool),
+	}
+	if f.gitIgnore {
+		w.ignore = loadGitIgnore(f.root)
+	}
+	if f.dockerIgnore {
+		w.docker = loadDockerIgnore(filepath.Join(f.root, ".dockerignore"))
+	}
+	if f.gitTracked {
+		tracked, err := gitTrackedFiles(ctx, f.root)
+		if err != nil {
+			return w, err
+		}
+		w.tracked = tracked
+	}
+	if f.onlyChanged {
+		changed, err := changedAbs(ctx, f.changedBase)
+		if err != nil {
+			return w, err
+		}
+		w.changed = changed
+		if w.rootAbs, err = filepath.Abs(f.root); err != nil {
+			return w, err
+		}
+	}
+	if real, err := filepath.EvalSymlinks(f.root); err == nil {
+		w.visited[real] = true
+	}
+	return w, nil
+}
+
+func gitTrackedFiles(ctx context.Context, root string) (map[string]bool, error) {
+	var out bytes.Buffer
+	if err := pipe.NewPiped("git", "ls-files", "-z").WithDir(root).ReadOnly().Execute(ctx, nil, &out, nil); err != nil {
+		return nil, fmt.Errorf("unable to list git tracked files in %s: %w", root, err)
+	}
+	ret := make(map[string]bool)
+	for _, file := range strings.Split(out.String(), "\x00") {
+		if file == "" {
+			continue
+		}
+		for p := file; p != "."; p = path.Dir(p) {
+			ret[p] = true
+		}
+	}
+	return ret, nil
+}
+
+func (w *walk) send(r Result) bool {
+	select {
+	case w.out <- r:
+		return true
+	case <-w.ctx.Done():
+		return false
+	}
+}
+
+// walk lists dir, which is at rel below the root.  It returns false once the walk should stop.
+func (w *walk) walk(dir string, rel []string, ignore *gitIgnore) bool {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return w.send(Result{Err: fmt.Errorf("unable to read directory %s: %w", dir, err)})
+	}
+	if ignore != nil {
+		ignore = ignore.enter(dir, rel)
+	}
+	for _, entry := range entries {
+		if w.ctx.Err() != nil {
+			return false
+		}
+		entryRel := append(append(make([]string, 0, len(rel)+1), rel...), entry.Name())
+		entryPath := filepath.Join(dir, entry.Name())
+		isDir := entry.IsDir()
+		if entry.Type()&fs.ModeSymlink != 0 {
+			switch w.symlinks {
+			case SymlinkSkip:
+				continue
+			case SymlinkList:
+				isDir = false
+			case SymlinkFollow:
+				info, err := os.Stat(entryPath)
+				if err != nil {
+					// Broken links have nothing to follow
+					continue
+				}
+				isDir = info.IsDir()
+			}
+		}
+		slashRel := strings.Join(entryRel, "/")
+		if isDir {
+			if !w.walkDir(entryPath, entryRel, slashRel, ignore) {
+				return false
+			}
+			continue
+		}
+		if w.skipFile(entry, entryRel, slashRel, ignore) {
+			continue
+		}
+		if !w.send(Result{Path: filepath.FromSlash(slashRel)}) {
+			return false
+		}
+	}
+	return true
+}
+
+func (w *walk) walkDir(dirPath string, rel []string, slashRel string, ignore *gitIgnore) bool {
+	if w.maxDepth > 0 && len(rel) >= w.maxDepth {
+		return true
+	}
+	for _, name := range w.excludeDirs {
+		if name == rel[len(rel)-1] {
+			return true
+		}
+	}
+	if matchesAny(w.excludes, slashRel) {
+		return true
+	}
+	if ignore != nil && ignore.ignored(rel, true) {
+		return true
+	}
+	if w.docker != nil && w.docker.skipDir(slashRel) {
+		return true
+	}
+	if w.tracked != nil && !w.tracked[slashRel] {
+		return true
+	}
+	if w.symlinks == SymlinkFollow {
+		real, err := filepath.EvalSymlinks(dirPath)
+		if err != nil {
+			return w.send(Result{Err: fmt.Errorf("unable to resolve %s: %w", dirPath, err)})
+		}
+		if w.visited[real] {
+			return true
+		}
+		w.visited[real] = true
+	}
+	return w.walk(dirPath, rel, ignore)
+}
+
+func (w *walk) skipFile(entry fs.DirEntry, rel []string, slashRel string, ignore *gitIgnore) bool {
+	if len(w.includes) > 0 && !matchesAny(w.includes, slashRel) {
+		return true
+	}
+	if matchesAny(w.excludes, slashRel) {
+		return true
+	}
+	if len(w.extensions) > 0 {
+		found := false
+		for _, ext := range w.extensions {
+			if hasExt(entry, ext) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return true
+		}
+	}
+	if ignore != nil && ignore.ignored(rel, false) {
+		return true
+	}
+	if w.docker != nil && w.docker.ignored(slashRel) {
+		return true
+	}
+	if w.tracked != nil && !w.tracked[slashRel] {
+		return true
+	}
+	if w.changed != nil && !w.changed[filepath.Join(w.rootAbs, filepath.FromSlash(slashRel))] {
+		return true
+	}
+	return false
+}
+
+func matchesAny(globs []string, name string) bool {
+	for _, g := range globs {
+		if Match(g, name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+package files
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFind(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"main.go":            "",
+		"main_test.go":       "",
+		"pkg/a/a.go":         "",
+		"pkg/a/a.pb.go":      "",
+		"pkg/a/b/c/deep.go":  "",
+		"pkg/readme.md":      "",
+		"vendor/x/vendor.go": "",
+	})
+	ctx := context.Background()
+	found, err := Find(root).Include("**/*.go").Exclude("*_test.go", "**/*.pb.go").All(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"main.go", filepath.FromSlash("pkg/a/a.go"), filepath.FromSlash("pkg/a/b/c/deep.go")}, found)
+
+	found, err = Find(root).Include("*.go").MaxDepth(3).Exclude("pkg/a/*.pb.go").ExcludeDirs().All(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"main.go", "main_test.go", filepath.FromSlash("pkg/a/a.go"), filepath.FromSlash("vendor/x/vendor.go")}, found)
+}
+
+func TestFind_DockerIgnore(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		".dockerignore":  "# comment\n*.md\n/build\n!build/keep.txt\n**/*.log\n",
+		"README.md":      "",
+		"docs/guide.md":  "",
+		"build/out.bin":  "",
+		"build/keep.txt": "",
+		"a/b/debug.log":  "",
+		"Dockerfile":     "",
+	})
+	found, err := Find(root).DockerIgnore().All(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []string{".dockerignore", "Dockerfile", filepath.FromSlash("build/keep.txt"), filepath.FromSlash("docs/guide.md")}, found)
+}
+
+func TestFind_Symlinks(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{"dir/file.txt": ""})
+	if err := os.Symlink(root, filepath.Join(root, "dir", "loop")); err != nil {
+		t.Skip("symlinks not supported:", err)
+	}
+	require.NoError(t, os.Symlink("file.txt", filepath.Join(root, "dir", "link.txt")))
+	ctx := context.Background()
+
+	found, err := Find(root).All(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{filepath.FromSlash("dir/file.txt")}, found)
+
+	found, err = Find(root).Symlinks(SymlinkList).All(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{filepath.FromSlash("dir/file.txt"), filepath.FromSlash("dir/link.txt"), filepath.FromSlash("dir/loop")}, found)
+
+	found, err = Find(root).Symlinks(SymlinkFollow).All(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{filepath.FromSlash("dir/file.txt"), filepath.FromSlash("dir/link.txt")}, found)
+}
+
+func TestFind_GitTracked(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{"tracked.txt": "", "sub/tracked.txt": "", "untracked.txt": "", "other/untracked.txt": ""})
+	for _, args := range [][]string{{"init", "-q"}, {"add", "tracked.txt", "sub/tracked.txt"}} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = root
+		require.NoError(t, cmd.Run())
+	}
+	found, err := Find(root).GitTracked().All(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []string{filepath.FromSlash("sub/tracked.txt"), "tracked.txt"}, found)
+}
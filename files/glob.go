@@ -0,0 +1,49 @@
+package files
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Match reports whether name matches the slash separated glob pattern.  Each segment of the pattern is matched
+// with path.Match, and a "**" segment matches zero or more whole segments.  A pattern without a slash is matched
+// against the base name of name, so "*.go" matches "a/b/c.go" like it would in a .gitignore file.
+func Match(pattern string, name string) bool {
+	name = filepath.ToSlash(name)
+	pattern = strings.TrimPrefix(pattern, "./")
+	if !strings.Contains(pattern, "/") {
+		ok, err := path.Match(pattern, path.Base(name))
+		return ok && err == nil
+	}
+	pattern = strings.TrimPrefix(pattern, "/")
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(strings.TrimPrefix(name, "./"), "/"))
+}
+
+func matchSegments(pattern []string, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			// Collapse repeated ** and try to match the rest of the pattern at every remaining position
+			for len(pattern) > 0 && pattern[0] == "**" {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				return true
+			}
+			for i := range name {
+				if matchSegments(pattern, name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, err := path.Match(pattern[0], name[0]); !ok || err != nil {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
@@ -0,0 +1,19 @@
+package files
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatch(t *testing.T) {
+	require.True(t, Match("*.go", "a/b/c.go"))
+	require.True(t, Match("**/*.go", "c.go"))
+	require.True(t, Match("**/*.go", "a/b/c.go"))
+	require.True(t, Match("a/**/c.go", "a/c.go"))
+	require.True(t, Match(".github/workflows/*.yml", "./.github/workflows/build.yml"))
+	require.True(t, Match("vendor/**", "vendor/x/y.go"))
+	require.False(t, Match("a/*.go", "a/b/c.go"))
+	require.False(t, Match("/c.go", "a/c.go"))
+	require.False(t, Match("**/*.go", "a/b/c.yaml"))
+}
@@ -0,0 +1,104 @@
+package files
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
+)
+
+// DefaultExcludedDirs are directory names that are never descended into when looking for files
+var DefaultExcludedDirs = []string{".git", "vendor", "node_modules"}
+
+// gitIgnore holds the .gitignore patterns that apply to a directory while walking a tree
+type gitIgnore struct {
+	patterns []gitignore.Pattern
+	// prefix is the path from the repository root to the walk root, as .gitignore patterns are relative to the
+	// repository
+	prefix []string
+}
+
+// loadGitIgnore reads .git/info/exclude and every .gitignore from the repository root containing dir down to, but not
+// including, dir itself.  The .gitignore of dir is read once the walk enters it.
+func loadGitIgnore(dir string) *gitIgnore {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return &gitIgnore{}
+	}
+	repoRoot := abs
+	for !IsDir(filepath.Join(repoRoot, ".git")) {
+		parent := filepath.Dir(repoRoot)
+		if parent == repoRoot {
+			// Not inside a repository: only .gitignore files inside dir apply
+			return &gitIgnore{}
+		}
+		repoRoot = parent
+	}
+	rel, err := filepath.Rel(repoRoot, abs)
+	if err != nil {
+		return &gitIgnore{}
+	}
+	var prefix []string
+	if rel != "." {
+		prefix = strings.Split(filepath.ToSlash(rel), "/")
+	}
+	ret := &gitIgnore{
+		patterns: readIgnoreFile(filepath.Join(repoRoot, ".git", "info", "exclude"), nil),
+	}
+	current := repoRoot
+	for i := range prefix {
+		ret = ret.enterAbs(current, prefix[:i])
+		current = filepath.Join(current, prefix[i])
+	}
+	ret.prefix = prefix
+	return ret
+}
+
+// enter returns the patterns that apply inside dir, which is at rel (relative to the walk root)
+func (g *gitIgnore) enter(dir string, rel []string) *gitIgnore {
+	return g.enterAbs(dir, append(append([]string(nil), g.prefix...), rel...))
+}
+
+func (g *gitIgnore) enterAbs(dir string, domain []string) *gitIgnore {
+	more := readIgnoreFile(filepath.Join(dir, ".gitignore"), domain)
+	if len(more) == 0 {
+		return g
+	}
+	patterns := make([]gitignore.Pattern, 0, len(g.patterns)+len(more))
+	patterns = append(patterns, g.patterns...)
+	return &gitIgnore{
+		patterns: append(patterns, more...),
+		prefix:   g.prefix,
+	}
+}
+
+// ignored reports if the path rel, relative to the walk root, is ignored
+func (g *gitIgnore) ignored(rel []string, isDir bool) bool {
+	if len(g.patterns) == 0 {
+		return false
+	}
+	full := append(append([]string(nil), g.prefix...), rel...)
+	return gitignore.NewMatcher(g.patterns).Match(full, isDir)
+}
+
+func readIgnoreFile(path string, domain []string) []gitignore.Pattern {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	var ret []gitignore.Pattern
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") || len(strings.TrimSpace(line)) == 0 {
+			continue
+		}
+		ret = append(ret, gitignore.ParsePattern(line, domain))
+	}
+	return ret
+}
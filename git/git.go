@@ -1,9 +1,14 @@
 package git
 
 import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
-	"github.com/magefile/mage/sh"
+	"github.com/cresta/magehelper/pipe"
 )
 
 type Git struct{}
@@ -11,9 +16,9 @@ type Git struct{}
 var Instance Git
 
 func (g *Git) GitRef() string {
-	s, err := sh.Output("git", "symbolic-ref", "HEAD")
+	s, err := pipe.NewPiped("git", "symbolic-ref", "HEAD").ReadOnly().Output(context.Background())
 	if err == nil {
-		return s
+		return strings.TrimSpace(s)
 	}
 	return ""
 }
@@ -33,22 +38,22 @@ func (g *Git) TagName(ref string) string {
 }
 
 func (g *Git) GitSHA() string {
-	s, err := sh.Output("git", "rev-parse", "--verify", "HEAD")
+	s, err := pipe.NewPiped("git", "rev-parse", "--verify", "HEAD").ReadOnly().Output(context.Background())
 	if err == nil {
-		return s
+		return strings.TrimSpace(s)
 	}
 	return ""
 }
 
 func (g *Git) RemoteRepository() string {
-	s, err := sh.Output("git", "config", "--get", "remote.origin.url")
+	s, err := pipe.NewPiped("git", "config", "--get", "remote.origin.url").ReadOnly().Output(context.Background())
 	if err != nil {
 		return ""
 	}
 	// S is something like
 	//  git@github.com:cresta/project.git
 	//  https://github.com/nginx/nginx.git
-	s = strings.TrimSuffix(s, ".git")
+	s = strings.TrimSuffix(strings.TrimSpace(s), ".git")
 	if strings.HasPrefix(s, "git@github.com:") {
 		return strings.TrimPrefix(s, "git@github.com:")
 	}
@@ -57,3 +62,109 @@ func (g *Git) RemoteRepository() string {
 	}
 	return ""
 }
+
+// Describe returns a human readable name for HEAD from the most recent tag, like v1.2.0-3-gdeadbee-dirty.  Without
+// any tags it is the abbreviated SHA.
+func (g *Git) Describe(ctx context.Context) (string, error) {
+	out, err := g.output(ctx, "describe", "--tags", "--always", "--dirty")
+	if err != nil {
+		return "", fmt.Errorf("unable to describe HEAD: %w", err)
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// CommitTime returns the committer time of HEAD
+func (g *Git) CommitTime(ctx context.Context) (time.Time, error) {
+	out, err := g.output(ctx, "log", "-1", "--format=%ct")
+	if err != nil {
+		return time.Time{}, fmt.Errorf("unable to read commit time: %w", err)
+	}
+	epoch, err := strconv.ParseInt(strings.TrimSpace(out), 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("unable to parse commit time %q: %w", out, err)
+	}
+	return time.Unix(epoch, 0).UTC(), nil
+}
+
+// MergeBase returns the best common ancestor commit of HEAD and ref
+func (g *Git) MergeBase(ctx context.Context, ref string) (string, error) {
+	out, err := g.output(ctx, "merge-base", "HEAD", ref)
+	if err != nil {
+		return "", fmt.Errorf("unable to find merge base of HEAD and %s: %w", ref, err)
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// DiffNames returns the files that differ between rev and the working tree, relative to the current directory.
+// Deleted files are not included.
+func (g *Git) DiffNames(ctx context.Context, rev string) ([]string, error) {
+	out, err := g.output(ctx, "diff", "--name-only", "--relative", "--diff-filter=d", "-z", rev)
+	if err != nil {
+		return nil, fmt.Errorf("unable to diff against %s: %w", rev, err)
+	}
+	return splitNull(out), nil
+}
+
+// UntrackedFiles returns files that are neither tracked nor ignored, relative to the current directory
+func (g *Git) UntrackedFiles(ctx context.Context) ([]string, error) {
+	out, err := g.output(ctx, "ls-files", "--others", "--exclude-standard", "-z")
+	if err != nil {
+		return nil, fmt.Errorf("unable to list untracked files: %w", err)
+	}
+	return splitNull(out), nil
+}
+
+// Prefix returns the path of the current directory relative to the root of the repository, with a trailing slash, or
+// "" at the root
+func (g *Git) Prefix(ctx context.Context) (string, error) {
+	out, err := g.output(ctx, "rev-parse", "--show-prefix")
+	if err != nil {
+		return "", fmt.Errorf("unable to find repository prefix: %w", err)
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// TopLevel returns the absolute path of the root of the repository
+func (g *Git) TopLevel(ctx context.Context) (string, error) {
+	out, err := g.output(ctx, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return "", fmt.Errorf("unable to find repository root: %w", err)
+	}
+	return filepath.FromSlash(strings.TrimSpace(out)), nil
+}
+
+// AddWorktree checks out rev, detached, into a new worktree at dir
+func (g *Git) AddWorktree(ctx context.Context, dir string, rev string) error {
+	if err := g.run(ctx, "worktree", "add", "--detach", dir, rev); err != nil {
+		return fmt.Errorf("unable to check out %s into %s: %w", rev, dir, err)
+	}
+	return nil
+}
+
+// RemoveWorktree deletes the worktree at dir, even if it has changes
+func (g *Git) RemoveWorktree(ctx context.Context, dir string) error {
+	if err := g.run(ctx, "worktree", "remove", "--force", dir); err != nil {
+		return fmt.Errorf("unable to remove worktree %s: %w", dir, err)
+	}
+	return nil
+}
+
+// output returns the stdout of a git command that only reads, so it runs in dry run mode too
+func (g *Git) output(ctx context.Context, args ...string) (string, error) {
+	return pipe.NewPiped("git", args...).ReadOnly().Output(ctx)
+}
+
+func (g *Git) run(ctx context.Context, args ...string) error {
+	_, err := pipe.NewPiped("git", args...).Output(ctx)
+	return err
+}
+
+func splitNull(s string) []string {
+	var ret []string
+	for _, part := range strings.Split(s, "\x00") {
+		if part != "" {
+			ret = append(ret, part)
+		}
+	}
+	return ret
+}
@@ -6,11 +6,14 @@ toolchain go1.22.1
 
 require (
 	github.com/go-git/go-git/v5 v5.12.0
-	github.com/google/shlex v0.0.0-20191202100458-e7afc7fbc510
 	github.com/magefile/mage v1.15.0
+	github.com/santhosh-tekuri/jsonschema/v5 v5.3.1
 	github.com/sethvargo/go-githubactions v1.2.0
 	github.com/stretchr/testify v1.9.0
+	golang.org/x/sync v0.7.0
+	golang.org/x/term v0.18.0
 	gopkg.in/yaml.v3 v3.0.1
+	mvdan.cc/sh/v3 v3.7.0
 )
 
 require (
@@ -0,0 +1,205 @@
+package gobuild
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/cresta/magehelper/cicd"
+	"github.com/cresta/magehelper/files"
+	"github.com/cresta/magehelper/pipe"
+)
+
+// BenchConfig configures BenchWithConfig
+type BenchConfig struct {
+	// Packages to benchmark.  Defaults to ${GO_BENCH_PACKAGES}, or ./...
+	Packages []string
+	// Bench only runs benchmarks matching the regular expression.  Defaults to ${GO_BENCH}, or .
+	Bench string
+	// Count is how many times each benchmark runs.  More runs make the comparison more reliable.  Defaults to
+	// ${GO_BENCH_COUNT}, or 6
+	Count int
+	// BenchTime is passed to -benchtime.  Defaults to ${GO_BENCH_TIME}
+	BenchTime string
+	// Dir is where results are stored, in a file named after the commit SHA.  Defaults to ${GO_BENCH_DIR}, or .bench
+	Dir string
+	// Baseline is a git ref whose merge base with HEAD is compared against, or file: followed by the path of a results
+	// file.  When there are no stored results for the merge base, its benchmarks are run in a temporary git worktree.
+	// Defaults to ${GO_BENCH_BASELINE}, or the target branch of the pull request being built, or main
+	Baseline string
+	// Threshold is how many percent worse a benchmark may get before it counts as a regression.  Defaults to
+	// ${GO_BENCH_THRESHOLD}, or 10
+	Threshold float64
+	// Alpha is the significance level a change must reach to count.  Defaults to 0.05
+	Alpha float64
+}
+
+func (g *Go) withBenchDefaults(config BenchConfig) (BenchConfig, error) {
+	if len(config.Packages) == 0 {
+		config.Packages = splitList(g.Env.GetDefault("GO_BENCH_PACKAGES", "./..."))
+	}
+	if config.Bench == "" {
+		config.Bench = g.Env.GetDefault("GO_BENCH", ".")
+	}
+	if config.Count == 0 {
+		count, err := strconv.Atoi(g.Env.GetDefault("GO_BENCH_COUNT", "6"))
+		if err != nil {
+			return config, fmt.Errorf("invalid GO_BENCH_COUNT: %w", err)
+		}
+		config.Count = count
+	}
+	if config.BenchTime == "" {
+		config.BenchTime = g.Env.Get("GO_BENCH_TIME")
+	}
+	if config.Dir == "" {
+		config.Dir = g.Env.GetDefault("GO_BENCH_DIR", ".bench")
+	}
+	if config.Baseline == "" {
+		config.Baseline = g.Env.Get("GO_BENCH_BASELINE")
+	}
+	if config.Baseline == "" {
+		config.Baseline = "main"
+		if pr, ok := g.cicd().(cicd.PullRequest); ok && pr.PullRequestBase() != "" {
+			config.Baseline = "origin/" + pr.PullRequestBase()
+		}
+	}
+	if config.Threshold == 0 {
+		threshold, err := strconv.ParseFloat(strings.TrimSuffix(g.Env.GetDefault("GO_BENCH_THRESHOLD", "10"), "%"), 64)
+		if err != nil {
+			return config, fmt.Errorf("invalid GO_BENCH_THRESHOLD: %w", err)
+		}
+		config.Threshold = threshold
+	}
+	if config.Alpha == 0 {
+		config.Alpha = 0.05
+	}
+	return config, nil
+}
+
+// runBench runs the benchmarks of config in dir, copying the output to stdout, and returns the output
+func runBench(ctx context.Context, config BenchConfig, dir string) ([]byte, error) {
+	args := []string{"test", "-run", "^$", "-bench", config.Bench, "-benchmem", "-count", strconv.Itoa(config.Count)}
+	if config.BenchTime != "" {
+		args = append(args, "-benchtime", config.BenchTime)
+	}
+	args = append(args, config.Packages...)
+	var out bytes.Buffer
+	if err := pipe.NewPiped("go", args...).WithDir(dir).Execute(ctx, nil, io.MultiWriter(&out, os.Stdout), os.Stderr); err != nil {
+		return nil, fmt.Errorf("unable to run benchmarks: %w", err)
+	}
+	return out.Bytes(), nil
+}
+
+// benchBaseline returns the results to compare against, or nil if there is no baseline
+func (g *Go) benchBaseline(ctx context.Context, config BenchConfig, head string) ([]byte, error) {
+	if path, ok := strings.CutPrefix(config.Baseline, "file:"); ok {
+		out, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read baseline %s: %w", path, err)
+		}
+		return out, nil
+	}
+	base, err := g.git().MergeBase(ctx, config.Baseline)
+	if err != nil {
+		fmt.Printf("No baseline to compare against: %v\n", err)
+		return nil, nil
+	}
+	if base == head {
+		fmt.Printf("HEAD is the baseline %s: nothing to compare against\n", config.Baseline)
+		return nil, nil
+	}
+	stored := filepath.Join(config.Dir, base+".txt")
+	if files.FileExists(stored) {
+		fmt.Printf("Comparing against %s\n", stored)
+		return os.ReadFile(stored)
+	}
+	fmt.Printf("Running benchmarks of %s (%s)\n", config.Baseline, base)
+	worktree, err := os.MkdirTemp("", "bench-")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = os.RemoveAll(worktree)
+	}()
+	prefix, err := g.git().Prefix(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := g.git().AddWorktree(ctx, worktree, base); err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = g.git().RemoveWorktree(context.WithoutCancel(ctx), worktree)
+	}()
+	out, err := runBench(ctx, config, filepath.Join(worktree, prefix))
+	if err != nil {
+		return nil, fmt.Errorf("unable to benchmark %s: %w", config.Baseline, err)
+	}
+	return out, writeBenchResults(stored, out)
+}
+
+func writeBenchResults(path string, results []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return fmt.Errorf("unable to create %s: %w", filepath.Dir(path), err)
+	}
+	if err := os.WriteFile(path, results, 0o600); err != nil {
+		return fmt.Errorf("unable to write benchmark results %s: %w", path, err)
+	}
+	return nil
+}
+
+// Bench runs the benchmarks, stores the results under the commit SHA, and compares them with the baseline.  It fails
+// when a benchmark got significantly worse by more than the threshold.  See BenchConfig
+func (g *Go) Bench(ctx context.Context) error {
+	return g.BenchWithConfig(ctx, BenchConfig{})
+}
+
+// BenchWithConfig benchmarks and compares config.  See Bench
+func (g *Go) BenchWithConfig(ctx context.Context, config BenchConfig) error {
+	config, err := g.withBenchDefaults(config)
+	if err != nil {
+		return err
+	}
+	head := g.git().GitSHA()
+	out, err := runBench(ctx, config, "")
+	if err != nil {
+		return err
+	}
+	if head != "" {
+		if err := writeBenchResults(filepath.Join(config.Dir, head+".txt"), out); err != nil {
+			return err
+		}
+	}
+	baselineOut, err := g.benchBaseline(ctx, config, head)
+	if err != nil || baselineOut == nil {
+		return err
+	}
+	current, err := parseBench(bytes.NewReader(out))
+	if err != nil {
+		return err
+	}
+	baseline, err := parseBench(bytes.NewReader(baselineOut))
+	if err != nil {
+		return err
+	}
+	comparisons := compareBench(baseline, current)
+	fmt.Println()
+	if err := printBenchComparison(os.Stdout, comparisons, config.Alpha); err != nil {
+		return err
+	}
+	var regressed []string
+	for _, c := range comparisons {
+		if worse := c.regression(config.Alpha); worse > config.Threshold {
+			regressed = append(regressed, fmt.Sprintf("%s.%s %s (%+.1f%%)", c.Key.Package, c.Key.Name, c.Key.Unit, c.Delta))
+		}
+	}
+	if len(regressed) > 0 {
+		return fmt.Errorf("benchmarks regressed by more than %.0f%%: %s", config.Threshold, strings.Join(regressed, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package gobuild
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cresta/magehelper/cicd/githubactions"
+	"github.com/cresta/magehelper/env"
+	"github.com/cresta/magehelper/pipe"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGo_benchBaseline(t *testing.T) {
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(dir))
+	defer func() {
+		require.NoError(t, os.Chdir(wd))
+	}()
+	// The binary of Build is named like the default baseline ref
+	require.NoError(t, os.WriteFile("main", []byte("\x7fELF"), 0o600))
+	require.NoError(t, writeBenchResults(filepath.Join(".bench", "abc123.txt"), []byte("BenchmarkParse 1 100 ns/op\n")))
+	require.NoError(t, os.WriteFile("old.txt", []byte("BenchmarkParse 1 90 ns/op\n"), 0o600))
+
+	e := env.NewFromMap(map[string]string{})
+	g := Go{Env: *e, CiCd: &githubactions.GithubActions{Env: e}}
+	rec := &pipe.Recording{
+		Stub: func(pipeline []pipe.Command, _ io.Reader, stdout io.Writer) error {
+			_, err := io.WriteString(stdout, "abc123\n")
+			return err
+		},
+	}
+	defer pipe.SetRecorder(rec)()
+	config, err := g.withBenchDefaults(BenchConfig{})
+	require.NoError(t, err)
+	require.Equal(t, "main", config.Baseline)
+	out, err := g.benchBaseline(context.Background(), config, "def456")
+	require.NoError(t, err)
+	require.Equal(t, "BenchmarkParse 1 100 ns/op\n", string(out))
+	require.Equal(t, []string{"git merge-base HEAD main"}, rec.Commands())
+
+	config.Baseline = "file:old.txt"
+	out, err = g.benchBaseline(context.Background(), config, "def456")
+	require.NoError(t, err)
+	require.Equal(t, "BenchmarkParse 1 90 ns/op\n", string(out))
+}
@@ -0,0 +1,264 @@
+package gobuild
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// benchKey identifies what a benchmark measured: the package, the benchmark name with its -GOMAXPROCS suffix and the
+// unit, like ns/op
+type benchKey struct {
+	Package string
+	Name    string
+	Unit    string
+}
+
+// benchResults are every measurement of each benchmark in `go test -bench` output
+type benchResults struct {
+	values map[benchKey][]float64
+	order  []benchKey
+}
+
+func parseBench(r io.Reader) (*benchResults, error) {
+	ret := &benchResults{values: make(map[benchKey][]float64)}
+	pkg := ""
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if p, found := strings.CutPrefix(line, "pkg: "); found {
+			pkg = strings.TrimSpace(p)
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 4 || len(fields)%2 != 0 || !strings.HasPrefix(fields[0], "Benchmark") {
+			continue
+		}
+		if _, err := strconv.Atoi(fields[1]); err != nil {
+			continue
+		}
+		for i := 2; i < len(fields); i += 2 {
+			v, err := strconv.ParseFloat(fields[i], 64)
+			if err != nil {
+				break
+			}
+			key := benchKey{Package: pkg, Name: fields[0], Unit: fields[i+1]}
+			if _, exists := ret.values[key]; !exists {
+				ret.order = append(ret.order, key)
+			}
+			ret.values[key] = append(ret.values[key], v)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read benchmark results: %w", err)
+	}
+	return ret, nil
+}
+
+// benchComparison is how a benchmark changed between two runs
+type benchComparison struct {
+	Key benchKey
+	// Old and New are the medians of each run
+	Old float64
+	New float64
+	// Delta is the change from Old to New, in percent
+	Delta float64
+	// P is the probability that a difference this large happens by chance, from a Mann-Whitney U test
+	P    float64
+	NOld int
+	NNew int
+}
+
+// higherIsBetter reports if a larger value of unit is an improvement, like MB/s, instead of ns/op
+func higherIsBetter(unit string) bool {
+	return strings.HasSuffix(unit, "/s")
+}
+
+// regression returns how much worse, in percent, the benchmark got when the change is statistically significant
+// at alpha, or 0
+func (c benchComparison) regression(alpha float64) float64 {
+	if c.P >= alpha {
+		return 0
+	}
+	worse := c.Delta
+	if higherIsBetter(c.Key.Unit) {
+		worse = -worse
+	}
+	return math.Max(worse, 0)
+}
+
+// compareBench compares every benchmark that is in both old and new
+func compareBench(old *benchResults, new *benchResults) []benchComparison {
+	var ret []benchComparison
+	for _, key := range new.order {
+		before, exists := old.values[key]
+		if !exists {
+			continue
+		}
+		after := new.values[key]
+		c := benchComparison{
+			Key:  key,
+			Old:  median(before),
+			New:  median(after),
+			P:    mannWhitneyP(before, after),
+			NOld: len(before),
+			NNew: len(after),
+		}
+		switch {
+		case c.Old != 0:
+			c.Delta = 100 * (c.New - c.Old) / c.Old
+		case c.New != 0:
+			c.Delta = math.Inf(1)
+		}
+		ret = append(ret, c)
+	}
+	return ret
+}
+
+func median(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	n := len(sorted)
+	if n == 0 {
+		return 0
+	}
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+// mannWhitneyP returns the two sided p-value of the Mann-Whitney U test that x and y come from the same
+// distribution.  Small samples without ties use the exact distribution of U, like benchstat, others the normal
+// approximation with a tie correction.
+func mannWhitneyP(x []float64, y []float64) float64 {
+	n1, n2 := len(x), len(y)
+	if n1 == 0 || n2 == 0 {
+		return 1
+	}
+	type sample struct {
+		value float64
+		fromX bool
+	}
+	all := make([]sample, 0, n1+n2)
+	for _, v := range x {
+		all = append(all, sample{value: v, fromX: true})
+	}
+	for _, v := range y {
+		all = append(all, sample{value: v})
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].value < all[j].value
+	})
+	// Rank with ties getting the average of their ranks
+	rankX := 0.0
+	tieCorrection := 0.0
+	for i := 0; i < len(all); {
+		j := i
+		for j < len(all) && all[j].value == all[i].value {
+			j++
+		}
+		rank := float64(i+j+1) / 2
+		for k := i; k < j; k++ {
+			if all[k].fromX {
+				rankX += rank
+			}
+		}
+		t := float64(j - i)
+		tieCorrection += t*t*t - t
+		i = j
+	}
+	u := rankX - float64(n1*(n1+1))/2
+	if tieCorrection == 0 && n1 <= 50 && n2 <= 50 {
+		return exactMannWhitneyP(n1, n2, u)
+	}
+	n := float64(n1 + n2)
+	mean := float64(n1*n2) / 2
+	variance := float64(n1*n2) / 12 * ((n + 1) - tieCorrection/(n*(n-1)))
+	if variance <= 0 {
+		return 1
+	}
+	z := (math.Abs(u-mean) - 0.5) / math.Sqrt(variance)
+	if z < 0 {
+		return 1
+	}
+	return math.Min(1, math.Erfc(z/math.Sqrt2))
+}
+
+// exactMannWhitneyP computes the two sided p-value of u by counting, for every possible U, the orderings of n1 and
+// n2 distinct values that give it
+func exactMannWhitneyP(n1 int, n2 int, u float64) float64 {
+	// counts[j][k] is the number of orderings of i values from x and j values from y with U = k, for the current i
+	counts := make([][]float64, n2+1)
+	for j := range counts {
+		counts[j] = []float64{1}
+	}
+	for i := 1; i <= n1; i++ {
+		next := make([][]float64, n2+1)
+		next[0] = []float64{1}
+		for j := 1; j <= n2; j++ {
+			row := make([]float64, i*j+1)
+			for k := range row {
+				// The largest value is from x, beating all j values of y, or from y, beating nothing
+				if k >= j && k-j < len(counts[j]) {
+					row[k] += counts[j][k-j]
+				}
+				if k < len(next[j-1]) {
+					row[k] += next[j-1][k]
+				}
+			}
+			next[j] = row
+		}
+		counts = next
+	}
+	dist := counts[n2]
+	total := 0.0
+	for _, c := range dist {
+		total += c
+	}
+	lower, upper := 0.0, 0.0
+	for k, c := range dist {
+		if float64(k) <= u {
+			lower += c
+		}
+		if float64(k) >= u {
+			upper += c
+		}
+	}
+	return math.Min(1, 2*math.Min(lower, upper)/total)
+}
+
+func formatBenchValue(v float64) string {
+	abs := math.Abs(v)
+	switch {
+	case abs >= 1e9:
+		return strconv.FormatFloat(v/1e9, 'f', 2, 64) + "G"
+	case abs >= 1e6:
+		return strconv.FormatFloat(v/1e6, 'f', 2, 64) + "M"
+	case abs >= 1e3:
+		return strconv.FormatFloat(v/1e3, 'f', 2, 64) + "k"
+	default:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+}
+
+// printBenchComparison writes comparisons as a table.  Changes that are not significant at alpha show as ~
+func printBenchComparison(w io.Writer, comparisons []benchComparison, alpha float64) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "name\tunit\told\tnew\tdelta")
+	for _, c := range comparisons {
+		delta := "~"
+		if c.P < alpha {
+			delta = fmt.Sprintf("%+.2f%%", c.Delta)
+		}
+		fmt.Fprintf(tw, "%s.%s\t%s\t%s\t%s\t%s (p=%.3f n=%d+%d)\n", c.Key.Package, c.Key.Name, c.Key.Unit,
+			formatBenchValue(c.Old), formatBenchValue(c.New), delta, c.P, c.NOld, c.NNew)
+	}
+	return tw.Flush()
+}
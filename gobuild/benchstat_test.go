@@ -0,0 +1,47 @@
+package gobuild
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMannWhitneyP(t *testing.T) {
+	require.InDelta(t, 0.1, mannWhitneyP([]float64{1, 2, 3}, []float64{4, 5, 6}), 1e-9)
+	require.InDelta(t, 0.002165, mannWhitneyP([]float64{1, 2, 3, 4, 5, 6}, []float64{7, 8, 9, 10, 11, 12}), 1e-6)
+	require.InDelta(t, 0.7, mannWhitneyP([]float64{1, 3, 5}, []float64{2, 4, 6}), 1e-9)
+	require.Equal(t, 1.0, mannWhitneyP([]float64{5, 5, 5}, []float64{5, 5, 5}))
+}
+
+func TestCompareBench(t *testing.T) {
+	old, err := parseBench(strings.NewReader(`goos: linux
+pkg: example.com/a
+BenchmarkParse-8   	 1000	 100 ns/op	 10 B/op	 1 allocs/op
+BenchmarkParse-8   	 1000	 101 ns/op	 10 B/op	 1 allocs/op
+BenchmarkParse-8   	 1000	 99 ns/op	 10 B/op	 1 allocs/op
+BenchmarkParse-8   	 1000	 102 ns/op	 10 B/op	 1 allocs/op
+PASS
+`))
+	require.NoError(t, err)
+	current, err := parseBench(strings.NewReader(`pkg: example.com/a
+BenchmarkParse-8   	 1000	 130 ns/op	 10 B/op	 1 allocs/op
+BenchmarkParse-8   	 1000	 131 ns/op	 10 B/op	 1 allocs/op
+BenchmarkParse-8   	 1000	 129 ns/op	 10 B/op	 1 allocs/op
+BenchmarkParse-8   	 1000	 132 ns/op	 10 B/op	 1 allocs/op
+`))
+	require.NoError(t, err)
+	comparisons := compareBench(old, current)
+	require.Len(t, comparisons, 3)
+	require.Equal(t, benchKey{Package: "example.com/a", Name: "BenchmarkParse-8", Unit: "ns/op"}, comparisons[0].Key)
+	require.Equal(t, 100.5, comparisons[0].Old)
+	require.Equal(t, 130.5, comparisons[0].New)
+	require.InDelta(t, 100*30/100.5, comparisons[0].Delta, 1e-9)
+	require.InDelta(t, 2.0/70, comparisons[0].P, 1e-9)
+	require.InDelta(t, 100*30/100.5, comparisons[0].regression(0.05), 1e-9)
+	require.Zero(t, comparisons[0].regression(0.01))
+	require.Equal(t, benchKey{Package: "example.com/a", Name: "BenchmarkParse-8", Unit: "B/op"}, comparisons[1].Key)
+	require.Zero(t, comparisons[1].Delta)
+	require.Equal(t, 1.0, comparisons[1].P)
+	require.Zero(t, comparisons[1].regression(0.05))
+}
@@ -0,0 +1,186 @@
+package gobuild
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cresta/magehelper/pipe"
+)
+
+// BuildConfig configures BuildWithConfig.  The zero value builds a static linux/amd64 binary to ./main, like Build
+// always has.
+type BuildConfig struct {
+	// Package is the main package to build.  Defaults to ${GOBUILD_MAIN_DIRECTORY}, or the only directory in ./cmd
+	Package string
+	// Output is where the binary is written.  Defaults to ${GOBUILD_OUTPUT}, or main
+	Output string
+	// GOOS defaults to ${GOBUILD_GOOS}, or linux
+	GOOS string
+	// GOARCH defaults to ${GOBUILD_GOARCH}, or amd64
+	GOARCH string
+	// CGO enables cgo.  Without cgo, the binary is linked statically
+	CGO bool
+	// Tags are extra build tags
+	Tags []string
+	// TrimPath removes file system paths from the binary
+	TrimPath bool
+	// Vars are extra variables set with -X, keyed by the full name of the variable, like main.name
+	Vars map[string]string
+	// VersionPackage is the package whose version, commit and date variables are set from git and CI, following the
+	// goreleaser convention.  Defaults to main.  Set it to "-" to not set them.
+	VersionPackage string
+	// BuildTime is stamped into the date variable.  Defaults to ${SOURCE_DATE_EPOCH}, or the time of the last commit,
+	// so builds are reproducible.  Set ${GOBUILD_BUILD_TIME} to now to stamp the time of the build instead
+	BuildTime time.Time
+}
+
+func (g *Go) withBuildDefaults(ctx context.Context, config BuildConfig) BuildConfig {
+	if config.Package == "" {
+		config.Package = g.buildMainDirectory()
+	}
+	if config.Output == "" {
+		config.Output = g.Env.GetDefault("GOBUILD_OUTPUT", "main")
+	}
+	if config.GOOS == "" {
+		config.GOOS = g.Env.GetDefault("GOBUILD_GOOS", "linux")
+	}
+	if config.GOARCH == "" {
+		config.GOARCH = g.Env.GetDefault("GOBUILD_GOARCH", "amd64")
+	}
+	if config.VersionPackage == "" {
+		config.VersionPackage = "main"
+	}
+	if config.BuildTime.IsZero() {
+		config.BuildTime = g.buildTime(ctx)
+	}
+	return config
+}
+
+// Version is the version stamped into binaries: the git tag being built, or `git describe` otherwise
+func (g *Go) Version(ctx context.Context) string {
+	ref := g.cicd().GitRef()
+	if ref == "" {
+		ref = g.git().GitRef()
+	}
+	if tag := g.git().TagName(ref); tag != "" {
+		return tag
+	}
+	if d, err := g.git().Describe(ctx); err == nil {
+		return d
+	}
+	return "dev"
+}
+
+func (g *Go) commit() string {
+	if sha := g.cicd().GitSHA(); sha != "" {
+		return sha
+	}
+	return g.git().GitSHA()
+}
+
+// buildTime is ${SOURCE_DATE_EPOCH} if set, or the time of the last commit, so builds are reproducible.  It is now
+// when ${GOBUILD_BUILD_TIME} is now, or outside a git repository
+func (g *Go) buildTime(ctx context.Context) time.Time {
+	if epoch, err := strconv.ParseInt(g.Env.Get("SOURCE_DATE_EPOCH"), 10, 64); err == nil {
+		return time.Unix(epoch, 0).UTC()
+	}
+	if g.Env.Get("GOBUILD_BUILD_TIME") != "now" {
+		if t, err := g.git().CommitTime(ctx); err == nil {
+			return t
+		}
+	}
+	return time.Now().UTC()
+}
+
+func (g *Go) ldflags(ctx context.Context, config BuildConfig) (string, error) {
+	var flags []string
+	if !config.CGO {
+		flags = append(flags, `-extldflags "-f no-PIC -static"`)
+	}
+	vars := make(map[string]string, len(config.Vars)+3)
+	if config.VersionPackage != "-" {
+		vars[config.VersionPackage+".version"] = g.Version(ctx)
+		vars[config.VersionPackage+".commit"] = g.commit()
+		vars[config.VersionPackage+".date"] = config.BuildTime.UTC().Format(time.RFC3339)
+	}
+	for k, v := range config.Vars {
+		vars[k] = v
+	}
+	names := make([]string, 0, len(vars))
+	for k := range vars {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		quoted, err := quoteFlag(k + "=" + vars[k])
+		if err != nil {
+			return "", fmt.Errorf("unable to stamp %s: %w", k, err)
+		}
+		flags = append(flags, "-X", quoted)
+	}
+	return strings.Join(flags, " "), nil
+}
+
+// quoteFlag quotes s so go build reads it as a single -ldflags argument.  Go splits the flags on spaces, and strips
+// single or double quotes around them without unescaping anything, so values with both kinds of quotes cannot be
+// passed.
+func quoteFlag(s string) (string, error) {
+	if !strings.ContainsAny(s, " \t\n\r'\"") {
+		return s, nil
+	}
+	if !strings.Contains(s, "'") {
+		return "'" + s + "'", nil
+	}
+	if !strings.Contains(s, `"`) {
+		return `"` + s + `"`, nil
+	}
+	return "", fmt.Errorf("%q contains both single and double quotes", s)
+}
+
+// BuildArgs returns the arguments to `go build` and the environment variables for config
+func (g *Go) BuildArgs(ctx context.Context, config BuildConfig) ([]string, []string, error) {
+	config = g.withBuildDefaults(ctx, config)
+	args := []string{"build", "-o", config.Output}
+	if config.TrimPath {
+		args = append(args, "-trimpath")
+	}
+	ldflags, err := g.ldflags(ctx, config)
+	if err != nil {
+		return nil, nil, err
+	}
+	args = append(args, "-ldflags", ldflags)
+	var tags []string
+	if !config.CGO {
+		tags = append(tags, "osusergo", "netgo", "static_build")
+	}
+	tags = append(tags, config.Tags...)
+	if len(tags) > 0 {
+		args = append(args, "-tags", strings.Join(tags, " "))
+	}
+	args = append(args, config.Package)
+	cgo := "0"
+	if config.CGO {
+		cgo = "1"
+	}
+	return args, []string{"GOOS=" + config.GOOS, "GOARCH=" + config.GOARCH, "CGO_ENABLED=" + cgo}, nil
+}
+
+// BuildWithConfig builds the main package of config
+func (g *Go) BuildWithConfig(ctx context.Context, config BuildConfig) error {
+	config = g.withBuildDefaults(ctx, config)
+	if config.Package == "" {
+		return fmt.Errorf("unset build target: change mage file")
+	}
+	args, buildEnv, err := g.BuildArgs(ctx, config)
+	if err != nil {
+		return err
+	}
+	return pipe.NewPiped("go", args...).
+		WithEnv(g.Env.AddEnv(buildEnv...)).
+		Execute(ctx, nil, os.Stdout, os.Stderr)
+}
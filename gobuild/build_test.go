@@ -0,0 +1,126 @@
+package gobuild
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cresta/magehelper/cicd/githubactions"
+	"github.com/cresta/magehelper/env"
+	"github.com/cresta/magehelper/pipe"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGo_BuildArgs(t *testing.T) {
+	e := env.NewFromMap(map[string]string{
+		"GITHUB_REF":        "refs/tags/v1.2.3",
+		"GITHUB_SHA":        "deadbeef",
+		"SOURCE_DATE_EPOCH": "1700000000",
+	})
+	g := Go{
+		Env:  *e,
+		CiCd: &githubactions.GithubActions{Env: e},
+	}
+	args, buildEnv, err := g.BuildArgs(context.Background(), BuildConfig{Package: "./cmd/app"})
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"build", "-o", "main",
+		"-ldflags", `-extldflags "-f no-PIC -static" -X main.commit=deadbeef -X main.date=2023-11-14T22:13:20Z -X main.version=v1.2.3`,
+		"-tags", "osusergo netgo static_build",
+		"./cmd/app",
+	}, args)
+	require.Equal(t, []string{"GOOS=linux", "GOARCH=amd64", "CGO_ENABLED=0"}, buildEnv)
+
+	args, buildEnv, err = g.BuildArgs(context.Background(), BuildConfig{
+		Package:        "./cmd/cli",
+		Output:         "bin/cli",
+		GOOS:           "darwin",
+		GOARCH:         "arm64",
+		CGO:            true,
+		Tags:           []string{"extra"},
+		TrimPath:       true,
+		Vars:           map[string]string{"main.name": "my cli", "main.quote": `say "hi"`, "main.owner": "bob's"},
+		VersionPackage: "-",
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"build", "-o", "bin/cli", "-trimpath",
+		"-ldflags", `-X 'main.name=my cli' -X "main.owner=bob's" -X 'main.quote=say "hi"'`,
+		"-tags", "extra",
+		"./cmd/cli",
+	}, args)
+	require.Equal(t, []string{"GOOS=darwin", "GOARCH=arm64", "CGO_ENABLED=1"}, buildEnv)
+
+	// go build cannot read a value with both kinds of quotes
+	_, _, err = g.BuildArgs(context.Background(), BuildConfig{
+		Package:        "./cmd/cli",
+		Vars:           map[string]string{"main.name": `bob's "cli"`},
+		VersionPackage: "-",
+	})
+	require.ErrorContains(t, err, "main.name")
+}
+
+func TestGo_Build(t *testing.T) {
+	e := env.NewFromMap(map[string]string{
+		"GITHUB_REF":             "refs/tags/v1.2.3",
+		"GITHUB_SHA":             "deadbeef",
+		"SOURCE_DATE_EPOCH":      "1700000000",
+		"GOBUILD_MAIN_DIRECTORY": "./cmd/app",
+		"GOBUILD_GOARCH":         "arm64",
+	})
+	g := Go{
+		Env:  *e,
+		CiCd: &githubactions.GithubActions{Env: e},
+	}
+	rec := &pipe.Recording{}
+	defer pipe.SetRecorder(rec)()
+	require.NoError(t, g.Build(context.Background()))
+	require.Equal(t, []string{
+		`go build -o main -ldflags '-extldflags "-f no-PIC -static" -X main.commit=deadbeef -X main.date=2023-11-14T22:13:20Z -X main.version=v1.2.3' -tags 'osusergo netgo static_build' ./cmd/app`,
+	}, rec.Commands())
+	require.Subset(t, rec.Pipelines()[0][0].Env, []string{"GOOS=linux", "GOARCH=arm64", "CGO_ENABLED=0"})
+}
+
+func TestGo_BuildAll(t *testing.T) {
+	e := env.NewFromMap(map[string]string{
+		"GITHUB_SHA":             "deadbeef",
+		"SOURCE_DATE_EPOCH":      "1700000000",
+		"GOBUILD_MAIN_DIRECTORY": ".,./cmd/app",
+	})
+	g := Go{
+		Env:  *e,
+		CiCd: &githubactions.GithubActions{Env: e},
+	}
+	rec := &pipe.Recording{}
+	defer pipe.SetRecorder(rec)()
+	require.NoError(t, g.BuildAll(context.Background()))
+	var outputs []string
+	for _, pipeline := range rec.Pipelines() {
+		if args := pipeline[0].Args; args[0] == "go" && args[1] == "build" {
+			outputs = append(outputs, args[3])
+		}
+	}
+	// . is named after the directory it is
+	require.ElementsMatch(t, []string{filepath.Join("bin", "gobuild"), filepath.Join("bin", "app")}, outputs)
+}
+
+func TestGo_buildTime(t *testing.T) {
+	g := Go{Env: *env.NewFromMap(map[string]string{})}
+	rec := &pipe.Recording{
+		Stub: func(pipeline []pipe.Command, _ io.Reader, stdout io.Writer) error {
+			_, err := io.WriteString(stdout, "1600000000\n")
+			return err
+		},
+	}
+	defer pipe.SetRecorder(rec)()
+	// Builds are reproducible by default: they are stamped with the time of the last commit
+	require.Equal(t, time.Unix(1600000000, 0).UTC(), g.buildTime(context.Background()))
+	require.Equal(t, []string{"git log -1 --format=%ct"}, rec.Commands())
+
+	g.Env = *env.NewFromMap(map[string]string{"GOBUILD_BUILD_TIME": "now"})
+	require.WithinDuration(t, time.Now(), g.buildTime(context.Background()), time.Minute)
+	g.Env = *env.NewFromMap(map[string]string{"SOURCE_DATE_EPOCH": "1700000000", "GOBUILD_BUILD_TIME": "now"})
+	require.Equal(t, time.Unix(1700000000, 0).UTC(), g.buildTime(context.Background()))
+}
@@ -0,0 +1,121 @@
+package gobuild
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/cresta/magehelper/files"
+	"github.com/cresta/magehelper/pipe"
+	"golang.org/x/sync/errgroup"
+)
+
+// mainDirectories returns ${GOBUILD_MAIN_DIRECTORY} as a list, or every main package below ./cmd
+func (g *Go) mainDirectories(ctx context.Context) ([]string, error) {
+	if dirs := g.mainDirectoryList(); len(dirs) > 0 {
+		return dirs, nil
+	}
+	if !files.IsDir("./cmd") {
+		return nil, nil
+	}
+	var out bytes.Buffer
+	if err := pipe.NewPiped("go", "list", "-e", "-f", "{{.Name}} {{.Dir}}", "./cmd/...").ReadOnly().Execute(ctx, nil, &out, nil); err != nil {
+		return nil, fmt.Errorf("unable to list packages in ./cmd: %w", err)
+	}
+	wd, err := filepath.Abs(".")
+	if err != nil {
+		return nil, err
+	}
+	var ret []string
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		name, dir, found := strings.Cut(scanner.Text(), " ")
+		if !found || name != "main" {
+			continue
+		}
+		rel, err := filepath.Rel(wd, dir)
+		if err != nil {
+			return nil, fmt.Errorf("unable to make %s relative: %w", dir, err)
+		}
+		ret = append(ret, "./"+filepath.ToSlash(rel))
+	}
+	return ret, nil
+}
+
+// binaryName returns the name of the binary of the main package pkg: the name of its directory, also for packages like
+// .
+func binaryName(pkg string) (string, error) {
+	dir, err := filepath.Abs(pkg)
+	if err != nil {
+		return "", fmt.Errorf("unable to find directory of %s: %w", pkg, err)
+	}
+	return filepath.Base(dir), nil
+}
+
+func (g *Go) buildConcurrency() int {
+	if c, err := strconv.Atoi(g.Env.Get("GOBUILD_CONCURRENCY")); err == nil && c > 0 {
+		return c
+	}
+	return runtime.NumCPU()
+}
+
+// BuildAll builds every main package in ${GOBUILD_MAIN_DIRECTORY}, or below ./cmd, into ${GOBUILD_BIN_DIR}, bin by
+// default
+func (g *Go) BuildAll(ctx context.Context) error {
+	return g.BuildAllWithConfig(ctx, BuildConfig{})
+}
+
+// BuildAllWithConfig builds every main package concurrently, up to ${GOBUILD_CONCURRENCY} at once.  Each binary is
+// named after the directory of its package.  config.Package is ignored, and config.Output is the directory the
+// binaries are written to.
+func (g *Go) BuildAllWithConfig(ctx context.Context, config BuildConfig) error {
+	dirs, err := g.mainDirectories(ctx)
+	if err != nil {
+		return err
+	}
+	if len(dirs) == 0 {
+		return fmt.Errorf("no main packages found: set GOBUILD_MAIN_DIRECTORY or add them to ./cmd")
+	}
+	outDir := config.Output
+	if outDir == "" {
+		outDir = g.Env.GetDefault("GOBUILD_BIN_DIR", "bin")
+	}
+	config = g.withBuildDefaults(ctx, config)
+	configs := make([]BuildConfig, 0, len(dirs))
+	seen := make(map[string]string, len(dirs))
+	for _, dir := range dirs {
+		name, err := binaryName(dir)
+		if err != nil {
+			return err
+		}
+		if config.GOOS == "windows" {
+			name += ".exe"
+		}
+		if other, exists := seen[name]; exists {
+			return fmt.Errorf("main packages %s and %s would both build to %s", other, dir, name)
+		}
+		seen[name] = dir
+		c := config
+		c.Package = dir
+		c.Output = filepath.Join(outDir, name)
+		configs = append(configs, c)
+	}
+	eg, egCtx := errgroup.WithContext(ctx)
+	eg.SetLimit(g.buildConcurrency())
+	for _, c := range configs {
+		c := c
+		eg.Go(func() error {
+			if err := g.BuildWithConfig(egCtx, c); err != nil {
+				return fmt.Errorf("unable to build %s: %w", c.Package, err)
+			}
+			fmt.Printf("Built %s\n", c.Output)
+			return nil
+		})
+	}
+	return eg.Wait()
+}
@@ -0,0 +1,114 @@
+package gobuild
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/cresta/magehelper/files"
+	"github.com/cresta/magehelper/pipe"
+)
+
+type listedPackage struct {
+	ImportPath   string
+	Dir          string
+	Deps         []string
+	TestImports  []string
+	XTestImports []string
+}
+
+// affectedPackages returns the import paths of packages in pattern that contain a changed file, or depend on, or
+// import in their tests, a package that does.  Packages imported by tests count with their dependencies, so tests
+// using a helper package that depends on a changed package are affected too.  A changed go.mod or go.sum affects every
+// package.
+func affectedPackages(ctx context.Context, changed []string, pattern string) ([]string, error) {
+	var out bytes.Buffer
+	if err := pipe.NewPiped("go", "list", "-e", "-json", pattern).ReadOnly().Execute(ctx, nil, &out, nil); err != nil {
+		return nil, fmt.Errorf("unable to list go packages: %w", err)
+	}
+	var pkgs []listedPackage
+	dec := json.NewDecoder(&out)
+	for {
+		var p listedPackage
+		if err := dec.Decode(&p); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, fmt.Errorf("unable to decode go list output: %w", err)
+		}
+		pkgs = append(pkgs, p)
+	}
+	direct := make(map[string]bool)
+	for _, f := range changed {
+		abs, err := filepath.Abs(f)
+		if err != nil {
+			return nil, err
+		}
+		if base := filepath.Base(abs); base == "go.mod" || base == "go.sum" {
+			ret := make([]string, 0, len(pkgs))
+			for _, p := range pkgs {
+				ret = append(ret, p.ImportPath)
+			}
+			return ret, nil
+		}
+		for _, p := range pkgs {
+			if filepath.Dir(abs) == p.Dir || strings.HasPrefix(abs, filepath.Join(p.Dir, "testdata")+string(filepath.Separator)) {
+				direct[p.ImportPath] = true
+			}
+		}
+	}
+	byPath := make(map[string]listedPackage, len(pkgs))
+	for _, p := range pkgs {
+		byPath[p.ImportPath] = p
+	}
+	// Deps is already every package p depends on, but only for the code outside its tests
+	affected := func(importPath string) bool {
+		return direct[importPath] || anyIn(direct, byPath[importPath].Deps)
+	}
+	var ret []string
+	for _, p := range pkgs {
+		if affected(p.ImportPath) || anyAffected(affected, p.TestImports) || anyAffected(affected, p.XTestImports) {
+			ret = append(ret, p.ImportPath)
+		}
+	}
+	sort.Strings(ret)
+	return ret, nil
+}
+
+func anyIn(set map[string]bool, values []string) bool {
+	for _, v := range values {
+		if set[v] {
+			return true
+		}
+	}
+	return false
+}
+
+func anyAffected(affected func(string) bool, importPaths []string) bool {
+	for _, importPath := range importPaths {
+		if affected(importPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// testPackages returns the packages Test should run: ./... or, when only changed files are requested, the packages
+// affected by them.
+func (g *Go) testPackages(ctx context.Context) ([]string, error) {
+	base, ok := files.OnlyChanged(&g.Env)
+	if !ok {
+		return []string{"./..."}, nil
+	}
+	changed, err := files.Changed(ctx, base)
+	if err != nil {
+		return nil, fmt.Errorf("unable to find changed files: %w", err)
+	}
+	return affectedPackages(ctx, changed, "./...")
+}
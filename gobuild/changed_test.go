@@ -0,0 +1,38 @@
+package gobuild
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/cresta/magehelper/pipe"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAffectedPackages(t *testing.T) {
+	root := t.TempDir()
+	pkgs := []listedPackage{
+		{ImportPath: "example.com/db", Dir: filepath.Join(root, "db")},
+		{ImportPath: "example.com/testutil", Dir: filepath.Join(root, "testutil"), Deps: []string{"example.com/db"}},
+		// Only the tests of api use the helper that depends on the changed package
+		{ImportPath: "example.com/api", Dir: filepath.Join(root, "api"), XTestImports: []string{"example.com/testutil"}},
+		{ImportPath: "example.com/cli", Dir: filepath.Join(root, "cli"), TestImports: []string{"testing"}},
+	}
+	rec := &pipe.Recording{
+		Stub: func(pipeline []pipe.Command, _ io.Reader, stdout io.Writer) error {
+			enc := json.NewEncoder(stdout)
+			for _, p := range pkgs {
+				if err := enc.Encode(p); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+	defer pipe.SetRecorder(rec)()
+	affected, err := affectedPackages(context.Background(), []string{filepath.Join(root, "db", "db.go")}, "./...")
+	require.NoError(t, err)
+	require.Equal(t, []string{"example.com/api", "example.com/db", "example.com/testutil"}, affected)
+}
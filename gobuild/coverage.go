@@ -0,0 +1,524 @@
+package gobuild
+
+import (
+	"bufio"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cresta/magehelper/files"
+	"github.com/cresta/magehelper/pipe"
+)
+
+// DefaultCoverageExclude are globs of generated files left out of coverage when none are configured
+var DefaultCoverageExclude = []string{"*.pb.go", "*.pb.*.go", "*_gen.go", "*.gen.go", "*_generated.go", "zz_generated*.go"}
+
+// CoverageConfig configures CoverageWithConfig
+type CoverageConfig struct {
+	// Profiles are the coverage profiles to merge.  A directory is read as GOCOVERDIR data with `go tool covdata`.
+	// Defaults to ${GO_COVERAGE} and ${GO_INTEGRATION_COVERAGE}, skipping any that do not exist
+	Profiles []string
+	// Exclude are globs of files to leave out, matched against the import path of the file.  See files.Match.
+	// Defaults to ${GO_COVERAGE_EXCLUDE}, split on commas, or DefaultCoverageExclude
+	Exclude []string
+	// Minimum is the lowest total coverage percentage allowed.  Defaults to ${GO_COVERAGE_MIN}
+	Minimum float64
+	// PackageMinimum is the lowest coverage percentage allowed for each package.  Defaults to
+	// ${GO_COVERAGE_PACKAGE_MIN}
+	PackageMinimum float64
+	// PackageMinimums overrides PackageMinimum for packages matching a glob on their import path.  If more than one
+	// glob matches, the highest minimum is used
+	PackageMinimums map[string]float64
+	// Output is where the merged profile is written.  Defaults to ${GO_COVERAGE_OUTPUT}
+	Output string
+	// HTML is where the `go tool cover` HTML report is written.  Defaults to ${GO_COVERAGE_HTML}
+	HTML string
+	// Cobertura is where a Cobertura XML report is written.  Defaults to ${GO_COVERAGE_COBERTURA}
+	Cobertura string
+}
+
+func (g *Go) withCoverageDefaults(config CoverageConfig) (CoverageConfig, error) {
+	if len(config.Profiles) == 0 {
+		for _, name := range []string{"GO_COVERAGE", "GO_INTEGRATION_COVERAGE"} {
+			if p := g.Env.Get(name); p != "" && (files.FileExists(p) || files.IsDir(p)) {
+				config.Profiles = append(config.Profiles, p)
+			}
+		}
+	}
+	if config.Exclude == nil {
+		config.Exclude = DefaultCoverageExclude
+		if exclude := g.Env.Get("GO_COVERAGE_EXCLUDE"); exclude != "" {
+			config.Exclude = strings.Split(exclude, ",")
+		}
+	}
+	for _, m := range []struct {
+		value *float64
+		env   string
+	}{{&config.Minimum, "GO_COVERAGE_MIN"}, {&config.PackageMinimum, "GO_COVERAGE_PACKAGE_MIN"}} {
+		if *m.value != 0 || g.Env.Get(m.env) == "" {
+			continue
+		}
+		v, err := strconv.ParseFloat(strings.TrimSuffix(g.Env.Get(m.env), "%"), 64)
+		if err != nil {
+			return config, fmt.Errorf("invalid %s: %w", m.env, err)
+		}
+		*m.value = v
+	}
+	if config.Output == "" {
+		config.Output = g.Env.Get("GO_COVERAGE_OUTPUT")
+	}
+	if config.HTML == "" {
+		config.HTML = g.Env.Get("GO_COVERAGE_HTML")
+	}
+	if config.Cobertura == "" {
+		config.Cobertura = g.Env.Get("GO_COVERAGE_COBERTURA")
+	}
+	return config, nil
+}
+
+func (c *CoverageConfig) packageMinimum(pkg string) float64 {
+	ret := c.PackageMinimum
+	found := false
+	for glob, minimum := range c.PackageMinimums {
+		if !files.Match(glob, pkg) && glob != pkg {
+			continue
+		}
+		if !found || minimum > ret {
+			ret = minimum
+		}
+		found = true
+	}
+	return ret
+}
+
+// coverBlock is a line of a text coverage profile: file:startLine.startCol,endLine.endCol statements count
+type coverBlock struct {
+	File      string
+	StartLine int
+	StartCol  int
+	EndLine   int
+	EndCol    int
+	Stmts     int
+}
+
+// coverProfile is a merged text coverage profile
+type coverProfile struct {
+	Mode   string
+	Counts map[coverBlock]int
+}
+
+func newCoverProfile() *coverProfile {
+	return &coverProfile{Counts: make(map[coverBlock]int)}
+}
+
+// parse adds a text coverage profile to p.  Counts of the same block are added together, or, in set mode, or-ed.
+// Merging profiles with different modes gives a set mode profile.
+func (p *coverProfile) parse(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if mode, found := strings.CutPrefix(line, "mode: "); found {
+			if p.Mode == "" {
+				p.Mode = mode
+			} else if p.Mode != mode {
+				p.Mode = "set"
+			}
+			continue
+		}
+		if line == "" {
+			continue
+		}
+		block, count, err := parseCoverLine(line)
+		if err != nil {
+			return err
+		}
+		p.Counts[block] += count
+	}
+	return scanner.Err()
+}
+
+func parseCoverLine(line string) (coverBlock, int, error) {
+	var b coverBlock
+	idx := strings.LastIndex(line, ":")
+	if idx < 0 {
+		return b, 0, fmt.Errorf("invalid coverage line %q", line)
+	}
+	b.File = line[:idx]
+	var count int
+	if _, err := fmt.Sscanf(line[idx+1:], "%d.%d,%d.%d %d %d", &b.StartLine, &b.StartCol, &b.EndLine, &b.EndCol, &b.Stmts, &count); err != nil {
+		return b, 0, fmt.Errorf("invalid coverage line %q: %w", line, err)
+	}
+	return b, count, nil
+}
+
+// exclude removes the blocks of files matching any of the globs
+func (p *coverProfile) exclude(globs []string) {
+	for block := range p.Counts {
+		if matchesAnyGlob(globs, block.File) {
+			delete(p.Counts, block)
+		}
+	}
+}
+
+func matchesAnyGlob(globs []string, name string) bool {
+	for _, glob := range globs {
+		if glob = strings.TrimSpace(glob); glob != "" && files.Match(glob, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *coverProfile) sortedBlocks() []coverBlock {
+	ret := make([]coverBlock, 0, len(p.Counts))
+	for b := range p.Counts {
+		ret = append(ret, b)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		a, b := ret[i], ret[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		if a.StartLine != b.StartLine {
+			return a.StartLine < b.StartLine
+		}
+		return a.StartCol < b.StartCol
+	})
+	return ret
+}
+
+func (p *coverProfile) write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "mode: %s\n", p.Mode)
+	for _, b := range p.sortedBlocks() {
+		count := p.Counts[b]
+		if p.Mode == "set" && count > 1 {
+			count = 1
+		}
+		fmt.Fprintf(bw, "%s:%d.%d,%d.%d %d %d\n", b.File, b.StartLine, b.StartCol, b.EndLine, b.EndCol, b.Stmts, count)
+	}
+	return bw.Flush()
+}
+
+// coverage is covered and total statements
+type coverage struct {
+	Covered int
+	Total   int
+}
+
+func (c coverage) percent() float64 {
+	if c.Total == 0 {
+		return 100
+	}
+	return 100 * float64(c.Covered) / float64(c.Total)
+}
+
+// packages returns the statement coverage of each package, and of everything together
+func (p *coverProfile) packages() (map[string]coverage, coverage) {
+	ret := make(map[string]coverage)
+	var total coverage
+	for b, count := range p.Counts {
+		pkg := path.Dir(b.File)
+		c := ret[pkg]
+		c.Total += b.Stmts
+		total.Total += b.Stmts
+		if count > 0 {
+			c.Covered += b.Stmts
+			total.Covered += b.Stmts
+		}
+		ret[pkg] = c
+	}
+	return ret, total
+}
+
+// lineHits returns, per file, how often each line ran.  A line in more than one block gets the highest count
+func (p *coverProfile) lineHits() map[string]map[int]int {
+	ret := make(map[string]map[int]int)
+	for b, count := range p.Counts {
+		lines, exists := ret[b.File]
+		if !exists {
+			lines = make(map[int]int)
+			ret[b.File] = lines
+		}
+		end := b.EndLine
+		if b.EndCol <= 1 && end > b.StartLine {
+			// The block ends before anything on its last line
+			end--
+		}
+		for line := b.StartLine; line <= end; line++ {
+			if prev, exists := lines[line]; !exists || count > prev {
+				lines[line] = count
+			}
+		}
+	}
+	return ret
+}
+
+type coberturaCoverage struct {
+	XMLName         xml.Name           `xml:"coverage"`
+	LineRate        string             `xml:"line-rate,attr"`
+	BranchRate      string             `xml:"branch-rate,attr"`
+	LinesCovered    int                `xml:"lines-covered,attr"`
+	LinesValid      int                `xml:"lines-valid,attr"`
+	BranchesCovered int                `xml:"branches-covered,attr"`
+	BranchesValid   int                `xml:"branches-valid,attr"`
+	Complexity      string             `xml:"complexity,attr"`
+	Version         string             `xml:"version,attr"`
+	Timestamp       int64              `xml:"timestamp,attr"`
+	Sources         []string           `xml:"sources>source"`
+	Packages        []coberturaPackage `xml:"packages>package"`
+}
+
+type coberturaPackage struct {
+	Name       string           `xml:"name,attr"`
+	LineRate   string           `xml:"line-rate,attr"`
+	BranchRate string           `xml:"branch-rate,attr"`
+	Complexity string           `xml:"complexity,attr"`
+	Classes    []coberturaClass `xml:"classes>class"`
+}
+
+type coberturaClass struct {
+	Name       string          `xml:"name,attr"`
+	Filename   string          `xml:"filename,attr"`
+	LineRate   string          `xml:"line-rate,attr"`
+	BranchRate string          `xml:"branch-rate,attr"`
+	Complexity string          `xml:"complexity,attr"`
+	Methods    struct{}        `xml:"methods"`
+	Lines      []coberturaLine `xml:"lines>line"`
+}
+
+type coberturaLine struct {
+	Number int `xml:"number,attr"`
+	Hits   int `xml:"hits,attr"`
+}
+
+func lineRate(covered int, valid int) string {
+	if valid == 0 {
+		return "1"
+	}
+	return strconv.FormatFloat(float64(covered)/float64(valid), 'f', 4, 64)
+}
+
+// writeCobertura writes p as Cobertura XML.  dirs maps import paths to directories, so file names can be made
+// relative to source.  Files of packages not in dirs keep their import path.
+func (p *coverProfile) writeCobertura(w io.Writer, source string, dirs map[string]string, now time.Time) error {
+	ret := coberturaCoverage{
+		BranchRate: "0",
+		Complexity: "0",
+		Timestamp:  now.UnixMilli(),
+		Sources:    []string{source},
+	}
+	byPackage := make(map[string][]coberturaClass)
+	packageLines := make(map[string]coverage)
+	hits := p.lineHits()
+	for file, lines := range hits {
+		pkg := path.Dir(file)
+		filename := file
+		if dir, exists := dirs[pkg]; exists {
+			if rel, err := filepath.Rel(source, filepath.Join(dir, path.Base(file))); err == nil {
+				filename = filepath.ToSlash(rel)
+			}
+		}
+		class := coberturaClass{
+			Name:       path.Base(file),
+			Filename:   filename,
+			BranchRate: "0",
+			Complexity: "0",
+		}
+		var c coverage
+		for number, count := range lines {
+			class.Lines = append(class.Lines, coberturaLine{Number: number, Hits: count})
+			c.Total++
+			if count > 0 {
+				c.Covered++
+			}
+		}
+		sort.Slice(class.Lines, func(i, j int) bool {
+			return class.Lines[i].Number < class.Lines[j].Number
+		})
+		class.LineRate = lineRate(c.Covered, c.Total)
+		byPackage[pkg] = append(byPackage[pkg], class)
+		pc := packageLines[pkg]
+		pc.Covered += c.Covered
+		pc.Total += c.Total
+		packageLines[pkg] = pc
+		ret.LinesCovered += c.Covered
+		ret.LinesValid += c.Total
+	}
+	ret.LineRate = lineRate(ret.LinesCovered, ret.LinesValid)
+	pkgs := make([]string, 0, len(byPackage))
+	for pkg := range byPackage {
+		pkgs = append(pkgs, pkg)
+	}
+	sort.Strings(pkgs)
+	for _, pkg := range pkgs {
+		classes := byPackage[pkg]
+		sort.Slice(classes, func(i, j int) bool {
+			return classes[i].Filename < classes[j].Filename
+		})
+		ret.Packages = append(ret.Packages, coberturaPackage{
+			Name:       pkg,
+			LineRate:   lineRate(packageLines[pkg].Covered, packageLines[pkg].Total),
+			BranchRate: "0",
+			Complexity: "0",
+			Classes:    classes,
+		})
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(ret); err != nil {
+		return fmt.Errorf("unable to encode cobertura report: %w", err)
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// readCoverProfile adds the profile at name to p.  Directories are converted from GOCOVERDIR data first
+func readCoverProfile(ctx context.Context, p *coverProfile, name string) error {
+	if files.IsDir(name) {
+		tmp, err := os.CreateTemp("", "coverage-*.out")
+		if err != nil {
+			return err
+		}
+		_ = tmp.Close()
+		defer func() {
+			_ = os.Remove(tmp.Name())
+		}()
+		if err := pipe.NewPiped("go", "tool", "covdata", "textfmt", "-i="+name, "-o="+tmp.Name()).Run(ctx); err != nil {
+			return fmt.Errorf("unable to convert coverage data in %s: %w", name, err)
+		}
+		name = tmp.Name()
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return fmt.Errorf("unable to open coverage profile %s: %w", name, err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	if err := p.parse(f); err != nil {
+		return fmt.Errorf("unable to parse coverage profile %s: %w", name, err)
+	}
+	return nil
+}
+
+// Coverage merges the unit and integration test coverage profiles, prints the coverage of each package, writes the
+// configured reports and fails if coverage is below the configured minimums.  See CoverageConfig
+func (g *Go) Coverage(ctx context.Context) error {
+	return g.CoverageWithConfig(ctx, CoverageConfig{})
+}
+
+// CoverageWithConfig checks and reports coverage for config.  See Coverage
+func (g *Go) CoverageWithConfig(ctx context.Context, config CoverageConfig) error {
+	config, err := g.withCoverageDefaults(config)
+	if err != nil {
+		return err
+	}
+	if len(config.Profiles) == 0 {
+		return fmt.Errorf("no coverage profiles: set GO_COVERAGE or GO_INTEGRATION_COVERAGE and run the tests first")
+	}
+	profile := newCoverProfile()
+	for _, name := range config.Profiles {
+		if err := readCoverProfile(ctx, profile, name); err != nil {
+			return err
+		}
+	}
+	profile.exclude(config.Exclude)
+	if err := g.writeCoverageReports(ctx, profile, config); err != nil {
+		return err
+	}
+	return checkCoverage(os.Stdout, profile, config)
+}
+
+func (g *Go) writeCoverageReports(ctx context.Context, profile *coverProfile, config CoverageConfig) error {
+	merged := config.Output
+	if merged == "" && config.HTML != "" {
+		tmp, err := os.CreateTemp("", "coverage-*.out")
+		if err != nil {
+			return err
+		}
+		_ = tmp.Close()
+		merged = tmp.Name()
+		defer func() {
+			_ = os.Remove(merged)
+		}()
+	}
+	if merged != "" {
+		if err := writeFileWith(merged, profile.write); err != nil {
+			return fmt.Errorf("unable to write coverage profile %s: %w", merged, err)
+		}
+	}
+	if config.HTML != "" {
+		if err := pipe.NewPiped("go", "tool", "cover", "-html="+merged, "-o="+config.HTML).Run(ctx); err != nil {
+			return fmt.Errorf("unable to write coverage HTML report: %w", err)
+		}
+	}
+	if config.Cobertura != "" {
+		wd, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+		pkgs, _ := profile.packages()
+		names := make([]string, 0, len(pkgs))
+		for pkg := range pkgs {
+			names = append(names, pkg)
+		}
+		dirs := packageDirs(ctx, names)
+		err = writeFileWith(config.Cobertura, func(w io.Writer) error {
+			return profile.writeCobertura(w, wd, dirs, time.Now())
+		})
+		if err != nil {
+			return fmt.Errorf("unable to write cobertura report %s: %w", config.Cobertura, err)
+		}
+	}
+	return nil
+}
+
+func writeFileWith(name string, write func(w io.Writer) error) (retErr error) {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	defer closeWithErr(f, &retErr)
+	return write(f)
+}
+
+// checkCoverage prints the coverage of every package and returns an error if any minimum is not met
+func checkCoverage(w io.Writer, profile *coverProfile, config CoverageConfig) error {
+	pkgs, total := profile.packages()
+	names := make([]string, 0, len(pkgs))
+	for pkg := range pkgs {
+		names = append(names, pkg)
+	}
+	sort.Strings(names)
+	var failed []string
+	for _, pkg := range names {
+		c := pkgs[pkg]
+		line := fmt.Sprintf("%6.1f%%  %s", c.percent(), pkg)
+		if minimum := config.packageMinimum(pkg); c.percent() < minimum {
+			line += fmt.Sprintf("  (below minimum of %.1f%%)", minimum)
+			failed = append(failed, pkg)
+		}
+		fmt.Fprintln(w, line)
+	}
+	fmt.Fprintf(w, "%6.1f%%  total\n", total.percent())
+	if total.percent() < config.Minimum {
+		return fmt.Errorf("total coverage %.1f%% is below the minimum of %.1f%%", total.percent(), config.Minimum)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("coverage is below the minimum for %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package gobuild
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCoverProfile(t *testing.T) {
+	p := newCoverProfile()
+	require.NoError(t, p.parse(strings.NewReader(`mode: atomic
+example.com/a/a.go:3.10,5.2 2 1
+example.com/a/a.go:7.10,9.2 2 0
+example.com/a/a.pb.go:3.10,5.2 5 0
+example.com/b/b.go:3.10,5.2 1 0
+`)))
+	require.NoError(t, p.parse(strings.NewReader(`mode: atomic
+example.com/a/a.go:3.10,5.2 2 3
+example.com/a/a.go:7.10,9.2 2 1
+`)))
+	p.exclude(DefaultCoverageExclude)
+
+	var merged bytes.Buffer
+	require.NoError(t, p.write(&merged))
+	require.Equal(t, `mode: atomic
+example.com/a/a.go:3.10,5.2 2 4
+example.com/a/a.go:7.10,9.2 2 1
+example.com/b/b.go:3.10,5.2 1 0
+`, merged.String())
+
+	var out bytes.Buffer
+	config := CoverageConfig{Minimum: 50, PackageMinimums: map[string]float64{"example.com/b": 10}}
+	err := checkCoverage(&out, p, config)
+	require.EqualError(t, err, "coverage is below the minimum for example.com/b")
+	require.Contains(t, out.String(), " 80.0%  total\n")
+
+	var cobertura bytes.Buffer
+	require.NoError(t, p.writeCobertura(&cobertura, "/src", map[string]string{"example.com/a": "/src/a"}, time.Unix(0, 0)))
+	require.Contains(t, cobertura.String(), `<class name="a.go" filename="a/a.go" line-rate="1.0000" branch-rate="0" complexity="0">`)
+	require.Contains(t, cobertura.String(), `<line number="4" hits="4"></line>`)
+}
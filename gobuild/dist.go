@@ -0,0 +1,332 @@
+package gobuild
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// Platform is a GOOS/GOARCH pair to build for
+type Platform struct {
+	GOOS   string
+	GOARCH string
+}
+
+func (p Platform) String() string {
+	return p.GOOS + "/" + p.GOARCH
+}
+
+// DefaultPlatforms are the platforms Dist builds when none are configured
+var DefaultPlatforms = []Platform{
+	{GOOS: "linux", GOARCH: "amd64"},
+	{GOOS: "linux", GOARCH: "arm64"},
+	{GOOS: "darwin", GOARCH: "amd64"},
+	{GOOS: "darwin", GOARCH: "arm64"},
+	{GOOS: "windows", GOARCH: "amd64"},
+}
+
+// ParsePlatforms parses a comma or space separated list of GOOS/GOARCH pairs, like "linux/amd64,darwin/arm64"
+func ParsePlatforms(s string) ([]Platform, error) {
+	var ret []Platform
+	for _, part := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' }) {
+		goos, goarch, found := strings.Cut(part, "/")
+		if !found || goos == "" || goarch == "" {
+			return nil, fmt.Errorf("invalid platform %q: expected GOOS/GOARCH", part)
+		}
+		ret = append(ret, Platform{GOOS: goos, GOARCH: goarch})
+	}
+	return ret, nil
+}
+
+// DistConfig configures DistWithConfig
+type DistConfig struct {
+	// Build is the base configuration of each build.  Output, GOOS and GOARCH are set for each platform
+	Build BuildConfig
+	// Name is the name of the binary and the prefix of each archive.  Defaults to the directory of the main package
+	Name string
+	// Platforms defaults to ${GOBUILD_DIST_PLATFORMS}, or DefaultPlatforms
+	Platforms []Platform
+	// Dir is where archives are written.  Defaults to ${GOBUILD_DIST_DIR}, or dist
+	Dir string
+	// Files are globs of extra files added to each archive.  Defaults to LICENSE* and README*
+	Files []string
+	// Reproducible builds with -trimpath and writes archives with every time set to ${SOURCE_DATE_EPOCH}, or the
+	// time of the last commit, and without owners.  Defaults to ${GOBUILD_DIST_REPRODUCIBLE}
+	Reproducible bool
+}
+
+func (g *Go) withDistDefaults(ctx context.Context, config DistConfig) (DistConfig, error) {
+	if config.Build.Package == "" {
+		config.Build.Package = g.buildMainDirectory()
+	}
+	if config.Build.Package == "" {
+		return config, fmt.Errorf("unset build target: change mage file")
+	}
+	if config.Name == "" {
+		name, err := binaryName(config.Build.Package)
+		if err != nil {
+			return config, err
+		}
+		config.Name = name
+	}
+	if len(config.Platforms) == 0 {
+		platforms, err := ParsePlatforms(g.Env.Get("GOBUILD_DIST_PLATFORMS"))
+		if err != nil {
+			return config, err
+		}
+		config.Platforms = platforms
+	}
+	if len(config.Platforms) == 0 {
+		config.Platforms = DefaultPlatforms
+	}
+	if config.Dir == "" {
+		config.Dir = g.Env.GetDefault("GOBUILD_DIST_DIR", "dist")
+	}
+	if config.Files == nil {
+		config.Files = []string{"LICENSE*", "README*"}
+	}
+	if !config.Reproducible {
+		config.Reproducible, _ = strconv.ParseBool(g.Env.Get("GOBUILD_DIST_REPRODUCIBLE"))
+	}
+	if config.Reproducible {
+		config.Build.TrimPath = true
+		if config.Build.BuildTime.IsZero() {
+			if _, err := strconv.ParseInt(g.Env.Get("SOURCE_DATE_EPOCH"), 10, 64); err == nil {
+				config.Build.BuildTime = g.buildTime(ctx)
+			} else if t, err := g.git().CommitTime(ctx); err == nil {
+				config.Build.BuildTime = t
+			} else {
+				return config, fmt.Errorf("reproducible archives need SOURCE_DATE_EPOCH or a git commit: %w", err)
+			}
+		}
+	}
+	return config, nil
+}
+
+// archiveEntry is a file to put into a release archive
+type archiveEntry struct {
+	// Name is the path inside the archive
+	Name string
+	// Path is the file on disk
+	Path string
+	Mode os.FileMode
+}
+
+// Dist cross compiles the main package for every configured platform and packages each binary, with the license
+// and readme, into a .tar.gz (.zip for windows) archive.  A checksums.txt with the SHA-256 of every archive is
+// written next to them.
+func (g *Go) Dist(ctx context.Context) error {
+	return g.DistWithConfig(ctx, DistConfig{})
+}
+
+// DistWithConfig creates release archives for config.  See Dist
+func (g *Go) DistWithConfig(ctx context.Context, config DistConfig) error {
+	config, err := g.withDistDefaults(ctx, config)
+	if err != nil {
+		return err
+	}
+	extra, err := globFiles(config.Files)
+	if err != nil {
+		return err
+	}
+	version := strings.NewReplacer("/", "_", " ", "_").Replace(g.Version(ctx))
+	buildDir := filepath.Join(config.Dir, ".build")
+	if err := os.MkdirAll(buildDir, 0o750); err != nil {
+		return fmt.Errorf("unable to create %s: %w", buildDir, err)
+	}
+	defer func() {
+		_ = os.RemoveAll(buildDir)
+	}()
+	archives := make([]string, len(config.Platforms))
+	eg, egCtx := errgroup.WithContext(ctx)
+	eg.SetLimit(g.buildConcurrency())
+	for idx, platform := range config.Platforms {
+		idx, platform := idx, platform
+		eg.Go(func() error {
+			binary := config.Name
+			if platform.GOOS == "windows" {
+				binary += ".exe"
+			}
+			build := config.Build
+			build.GOOS, build.GOARCH = platform.GOOS, platform.GOARCH
+			build.Output = filepath.Join(buildDir, platform.GOOS+"_"+platform.GOARCH, binary)
+			if err := g.BuildWithConfig(egCtx, build); err != nil {
+				return fmt.Errorf("unable to build %s: %w", platform, err)
+			}
+			entries := append([]archiveEntry{{Name: binary, Path: build.Output, Mode: 0o755}}, extra...)
+			name := fmt.Sprintf("%s_%s_%s_%s", config.Name, version, platform.GOOS, platform.GOARCH)
+			var mtime *time.Time
+			if config.Reproducible {
+				mtime = &build.BuildTime
+			}
+			var err error
+			if platform.GOOS == "windows" {
+				archives[idx] = filepath.Join(config.Dir, name+".zip")
+				err = writeZip(archives[idx], entries, mtime)
+			} else {
+				archives[idx] = filepath.Join(config.Dir, name+".tar.gz")
+				err = writeTarGz(archives[idx], entries, mtime)
+			}
+			if err != nil {
+				return fmt.Errorf("unable to write archive for %s: %w", platform, err)
+			}
+			fmt.Println("Wrote", archives[idx])
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return err
+	}
+	return writeChecksums(filepath.Join(config.Dir, "checksums.txt"), archives)
+}
+
+func globFiles(globs []string) ([]archiveEntry, error) {
+	var ret []archiveEntry
+	seen := make(map[string]bool)
+	for _, glob := range globs {
+		matches, err := filepath.Glob(glob)
+		if err != nil {
+			return nil, fmt.Errorf("invalid glob %s: %w", glob, err)
+		}
+		for _, m := range matches {
+			if seen[m] {
+				continue
+			}
+			seen[m] = true
+			ret = append(ret, archiveEntry{Name: filepath.ToSlash(m), Path: m, Mode: 0o644})
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Name < ret[j].Name
+	})
+	return ret, nil
+}
+
+// writeTarGz writes entries into a gzipped tarball.  If mtime is set, every entry gets that modification time and
+// no owner, so the archive only depends on the content of the files.
+func writeTarGz(path string, entries []archiveEntry, mtime *time.Time) (retErr error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer closeWithErr(f, &retErr)
+	gz := gzip.NewWriter(f)
+	defer closeWithErr(gz, &retErr)
+	tw := tar.NewWriter(gz)
+	defer closeWithErr(tw, &retErr)
+	for _, e := range entries {
+		info, err := os.Stat(e.Path)
+		if err != nil {
+			return err
+		}
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = e.Name
+		hdr.Mode = int64(e.Mode)
+		hdr.Format = tar.FormatPAX
+		if mtime != nil {
+			hdr.ModTime = mtime.UTC()
+			hdr.AccessTime = time.Time{}
+			hdr.ChangeTime = time.Time{}
+			hdr.Uid, hdr.Gid = 0, 0
+			hdr.Uname, hdr.Gname = "", ""
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if err := copyFile(tw, e.Path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeZip writes entries into a zip archive.  See writeTarGz for mtime
+func writeZip(path string, entries []archiveEntry, mtime *time.Time) (retErr error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer closeWithErr(f, &retErr)
+	zw := zip.NewWriter(f)
+	defer closeWithErr(zw, &retErr)
+	for _, e := range entries {
+		info, err := os.Stat(e.Path)
+		if err != nil {
+			return err
+		}
+		hdr, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		hdr.Name = e.Name
+		hdr.Method = zip.Deflate
+		hdr.SetMode(e.Mode)
+		if mtime != nil {
+			hdr.Modified = mtime.UTC()
+		}
+		w, err := zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+		if err := copyFile(w, e.Path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyFile(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+func closeWithErr(c io.Closer, retErr *error) {
+	if err := c.Close(); err != nil && *retErr == nil {
+		*retErr = err
+	}
+}
+
+// writeChecksums writes the SHA-256 of each file in the format of sha256sum
+func writeChecksums(path string, archives []string) error {
+	sorted := append([]string(nil), archives...)
+	sort.Strings(sorted)
+	var sb strings.Builder
+	for _, a := range sorted {
+		f, err := os.Open(a)
+		if err != nil {
+			return err
+		}
+		h := sha256.New()
+		_, err = io.Copy(h, f)
+		_ = f.Close()
+		if err != nil {
+			return fmt.Errorf("unable to hash %s: %w", a, err)
+		}
+		sb.WriteString(hex.EncodeToString(h.Sum(nil)) + "  " + filepath.Base(a) + "\n")
+	}
+	//nolint:gosec // checksums are published next to the archives
+	return os.WriteFile(path, []byte(sb.String()), 0o644)
+}
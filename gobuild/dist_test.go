@@ -0,0 +1,48 @@
+package gobuild
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cresta/magehelper/env"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePlatforms(t *testing.T) {
+	platforms, err := ParsePlatforms("linux/amd64, darwin/arm64")
+	require.NoError(t, err)
+	require.Equal(t, []Platform{{GOOS: "linux", GOARCH: "amd64"}, {GOOS: "darwin", GOARCH: "arm64"}}, platforms)
+	_, err = ParsePlatforms("linux")
+	require.Error(t, err)
+}
+
+func TestReproducibleArchives(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "LICENSE")
+	require.NoError(t, os.WriteFile(file, []byte("MIT"), 0o600))
+	entries := []archiveEntry{{Name: "LICENSE", Path: file, Mode: 0o644}}
+	mtime := time.Unix(1700000000, 0)
+	for _, write := range []func(string, []archiveEntry, *time.Time) error{writeTarGz, writeZip} {
+		first, second := filepath.Join(dir, "first"), filepath.Join(dir, "second")
+		require.NoError(t, write(first, entries, &mtime))
+		require.NoError(t, os.Chtimes(file, time.Now(), time.Now().Add(time.Hour)))
+		require.NoError(t, write(second, entries, &mtime))
+		a, err := os.ReadFile(first)
+		require.NoError(t, err)
+		b, err := os.ReadFile(second)
+		require.NoError(t, err)
+		require.Equal(t, a, b)
+	}
+}
+
+func TestDistName(t *testing.T) {
+	g := Go{Env: *env.NewFromMap(map[string]string{})}
+	for pkg, name := range map[string]string{".": "gobuild", "./": "gobuild", "./cmd/app": "app", "./cmd/app/": "app"} {
+		config, err := g.withDistDefaults(context.Background(), DistConfig{Build: BuildConfig{Package: pkg}})
+		require.NoError(t, err)
+		require.Equal(t, name, config.Name, pkg)
+	}
+}
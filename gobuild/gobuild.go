@@ -5,20 +5,47 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/cresta/magehelper/cicd"
 	"github.com/cresta/magehelper/env"
 	"github.com/cresta/magehelper/files"
+	"github.com/cresta/magehelper/git"
 	"github.com/cresta/magehelper/pipe"
+	"github.com/cresta/magehelper/tools"
 )
 
 var Instance Go
 
 type Go struct {
-	Env env.Env
+	Env  env.Env
+	CiCd cicd.CiCd
+	Git  *git.Git
+}
+
+func (g *Go) cicd() cicd.CiCd {
+	if g.CiCd == nil {
+		return cicd.Instance()
+	}
+	return g.CiCd
+}
+
+func (g *Go) git() *git.Git {
+	if g.Git == nil {
+		return &git.Instance
+	}
+	return g.Git
+}
+
+// mainDirectoryList splits ${GOBUILD_MAIN_DIRECTORY} on commas and whitespace
+func (g *Go) mainDirectoryList() []string {
+	return splitList(g.Env.Get("GOBUILD_MAIN_DIRECTORY"))
 }
 
 func (g *Go) buildMainDirectory() string {
-	if dir := g.Env.Get("GOBUILD_MAIN_DIRECTORY"); dir != "" {
-		return dir
+	if dirs := g.mainDirectoryList(); len(dirs) > 0 {
+		if len(dirs) > 1 {
+			return ""
+		}
+		return dirs[0]
 	}
 	// Try to guess it. Should be something like ./cmd/<X> if X exists and is a directory
 	if !files.IsDir("./cmd") {
@@ -45,27 +72,34 @@ func (g *Go) buildMainDirectory() string {
 }
 
 func (g *Go) Lint(ctx context.Context) error {
-	return pipe.NewPiped("golangci-lint", "run").Execute(ctx, nil, os.Stdout, os.Stderr)
+	if err := tools.Require(ctx, "golangci-lint"); err != nil {
+		return err
+	}
+	args := []string{"run"}
+	if base, ok := files.OnlyChanged(&g.Env); ok {
+		rev, err := files.ChangedBase(ctx, base)
+		if err != nil {
+			return fmt.Errorf("unable to find base revision: %w", err)
+		}
+		args = append(args, "--new-from-rev="+rev)
+	}
+	return pipe.NewPiped("golangci-lint", args...).Execute(ctx, nil, os.Stdout, os.Stderr)
 }
 
+// Test runs the unit tests.  See TestConfig for what can be configured
 func (g *Go) Test(ctx context.Context) error {
-	args := []string{"test", "-v", "-race", "-benchtime", "1ns", "-bench", "."}
-	if profileOut := g.Env.Get("GO_COVERAGE"); profileOut != "" {
-		args = append(args, "-coverprofile", profileOut)
-	}
-	args = append(args, "./...")
-	return pipe.NewPiped("go", args...).
-		WithEnv(g.Env.AddEnv("GORACE=halt_on_error=1")).
-		Execute(ctx, nil, os.Stdout, os.Stderr)
+	return g.TestWithConfig(ctx, TestConfig{})
 }
 
+// IntegrationTest runs the tests with the integration build tag.  See TestConfig for what can be configured
 func (g *Go) IntegrationTest(ctx context.Context) error {
-	return pipe.NewPiped("go", "test", "--tags=integration ", "-v", "-race", "-benchtime", "1ns", "-bench", ".", "./...").
-		WithEnv(g.Env.AddEnv("GORACE=halt_on_error=1")).
-		Execute(ctx, nil, os.Stdout, os.Stderr)
+	return g.IntegrationTestWithConfig(ctx, TestConfig{})
 }
 
 func (g *Go) Reformat(ctx context.Context) error {
+	if err := tools.Require(ctx, "goimports"); err != nil {
+		return err
+	}
 	err := pipe.NewPiped("gofmt", "-s", "-w", ".").Execute(ctx, nil, os.Stdout, os.Stderr)
 	if err != nil {
 		return fmt.Errorf("unable to gofmt: %w", err)
@@ -75,36 +109,89 @@ func (g *Go) Reformat(ctx context.Context) error {
 		Execute(ctx, nil, os.Stdout, os.Stderr)
 }
 
+// Build builds the main package to ./main, or every main package into bin/ when there is more than one
 func (g *Go) Build(ctx context.Context) error {
-	if g.buildMainDirectory() == "" {
-		return fmt.Errorf("unset build target: change mage file")
+	if g.buildMainDirectory() != "" {
+		return g.BuildWithConfig(ctx, BuildConfig{})
+	}
+	dirs, err := g.mainDirectories(ctx)
+	if err != nil {
+		return err
+	}
+	switch len(dirs) {
+	case 0:
+		return g.BuildWithConfig(ctx, BuildConfig{})
+	case 1:
+		return g.BuildWithConfig(ctx, BuildConfig{Package: dirs[0]})
+	default:
+		return g.BuildAllWithConfig(ctx, BuildConfig{})
 	}
-	return pipe.NewPiped("go", "build", "-o", "main", "-ldflags", `-extldflags "-f no-PIC -static"`, "-tags", "osusergo netgo static_build", g.buildMainDirectory()).
-		WithEnv(g.Env.AddEnv("GOOS=linux", "GOARCH=amd64", "CGO_ENABLED=0")).
-		Execute(ctx, nil, os.Stdout, os.Stderr)
 }
 
-// Will build a static binary of the go program in the directory ${GOBUILD_MAIN_DIRECTORY}
+// Will build a static binary of the go program in the directory ${GOBUILD_MAIN_DIRECTORY}, stamped with its version
 func Build(ctx context.Context) error {
 	return Instance.Build(ctx)
 }
 
+// Build every main package in ${GOBUILD_MAIN_DIRECTORY}, or under ./cmd, into bin/<name>
+func BuildAll(ctx context.Context) error {
+	return Instance.BuildAll(ctx)
+}
+
+// Cross compile ${GOBUILD_DIST_PLATFORMS} into release archives with checksums in ${GOBUILD_DIST_DIR}
+func Dist(ctx context.Context) error {
+	return Instance.Dist(ctx)
+}
+
 // Format the code in place
 func Reformat(ctx context.Context) error {
 	return Instance.Reformat(ctx)
 }
 
-// Lints the current code using golangci-lint
+// Lints the current code using golangci-lint.  Only reports new issues if ${MAGEHELPER_ONLY_CHANGED} is set
 func Lint(ctx context.Context) error {
 	return Instance.Lint(ctx)
 }
 
-// Run a 'go test' against all code in this repository
+// Run a 'go test' against all code in this repository, or only affected packages if ${MAGEHELPER_ONLY_CHANGED} is set.
+// Prints a summary of failed, skipped and slow tests, writes JUnit XML to ${GO_JUNIT_REPORT} and reruns failed tests
+// ${GO_TEST_FLAKY_RERUNS} times.
 func Test(ctx context.Context) error {
 	return Instance.Test(ctx)
 }
 
-// Run a 'go test' with the integration flag for code in this repository
+// Run a 'go test' with the integration tag, or ${GO_INTEGRATION_TAGS}, for code in this repository.  Starts the
+// services in ${GO_INTEGRATION_COMPOSE} first and removes them afterwards.
 func IntegrationTest(ctx context.Context) error {
 	return Instance.IntegrationTest(ctx)
 }
+
+// Run benchmarks ${GO_BENCH_COUNT} times and fail if they regressed past ${GO_BENCH_THRESHOLD} percent of ${GO_BENCH_BASELINE}
+func Bench(ctx context.Context) error {
+	return Instance.Bench(ctx)
+}
+
+// Check that go mod tidy changes nothing, verify dependencies and scan them with govulncheck
+func ModCheck(ctx context.Context) error {
+	return Instance.ModCheck(ctx)
+}
+
+// Fail with a diff if go mod tidy would change go.mod or go.sum
+func ModTidyCheck(ctx context.Context) error {
+	return Instance.ModTidyCheck(ctx)
+}
+
+// Verify downloaded dependencies have not been modified
+func ModVerify(ctx context.Context) error {
+	return Instance.ModVerify(ctx)
+}
+
+// Fail if govulncheck finds called vulnerabilities that are not in ${GO_VULN_ALLOWLIST}
+func VulnCheck(ctx context.Context) error {
+	return Instance.VulnCheck(ctx)
+}
+
+// Merge ${GO_COVERAGE} and ${GO_INTEGRATION_COVERAGE}, write reports and check ${GO_COVERAGE_MIN}
+func Coverage(ctx context.Context) error {
+	return Instance.Coverage(ctx)
+}
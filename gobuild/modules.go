@@ -0,0 +1,319 @@
+package gobuild
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/cresta/magehelper/files"
+	"github.com/cresta/magehelper/pipe"
+	"github.com/cresta/magehelper/tools"
+)
+
+// ModTidyCheck fails, with a diff, if `go mod tidy` would change go.mod or go.sum.  The files are left as they were
+func (g *Go) ModTidyCheck(ctx context.Context) error {
+	names := []string{"go.mod", "go.sum"}
+	before := make(map[string][]byte, len(names))
+	for _, name := range names {
+		content, err := os.ReadFile(name)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("unable to read %s: %w", name, err)
+		}
+		before[name] = content
+	}
+	tidyErr := pipe.NewPiped("go", "mod", "tidy").Execute(ctx, nil, os.Stdout, os.Stderr)
+	var diffs []string
+	var changed []string
+	for _, name := range names {
+		after, err := os.ReadFile(name)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("unable to read %s: %w", name, err)
+		}
+		if bytes.Equal(before[name], after) {
+			continue
+		}
+		changed = append(changed, name)
+		diff, err := unifiedDiff(ctx, name, before[name], after)
+		if err != nil {
+			return err
+		}
+		diffs = append(diffs, diff)
+		if err := restoreFile(name, before[name]); err != nil {
+			return err
+		}
+	}
+	if tidyErr != nil {
+		return fmt.Errorf("unable to run go mod tidy: %w", tidyErr)
+	}
+	if len(changed) > 0 {
+		return fmt.Errorf("go mod tidy changes %s, run it and commit the result:\n%s", strings.Join(changed, " and "), strings.Join(diffs, ""))
+	}
+	return nil
+}
+
+// restoreFile writes content back to name, or removes name if content is nil because it did not exist
+func restoreFile(name string, content []byte) error {
+	if content == nil {
+		if err := os.Remove(name); err != nil {
+			return fmt.Errorf("unable to remove %s: %w", name, err)
+		}
+		return nil
+	}
+	info, err := os.Stat(name)
+	if err != nil {
+		return fmt.Errorf("unable to stat %s: %w", name, err)
+	}
+	if err := os.WriteFile(name, content, info.Mode()); err != nil {
+		return fmt.Errorf("unable to restore %s: %w", name, err)
+	}
+	return nil
+}
+
+// unifiedDiff returns a diff of the two versions of the file name, made with `git diff --no-index`
+func unifiedDiff(ctx context.Context, name string, before []byte, after []byte) (string, error) {
+	dir, err := os.MkdirTemp("", "diff-")
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	for _, side := range []struct {
+		dir     string
+		content []byte
+	}{{"a", before}, {"b", after}} {
+		if err := os.MkdirAll(filepath.Join(dir, side.dir), 0o750); err != nil {
+			return "", err
+		}
+		if err := os.WriteFile(filepath.Join(dir, side.dir, name), side.content, 0o600); err != nil {
+			return "", err
+		}
+	}
+	var out bytes.Buffer
+	// git diff exits with 1 when the files differ, which is expected here
+	_ = pipe.NewPiped("git", "diff", "--no-index", "--no-color", "--no-prefix", filepath.Join("a", name), filepath.Join("b", name)).
+		WithDir(dir).
+		Execute(ctx, nil, &out, io.Discard)
+	return out.String(), nil
+}
+
+// ModVerify checks that the downloaded dependencies have not been modified since they were downloaded
+func (g *Go) ModVerify(ctx context.Context) error {
+	if err := pipe.NewPiped("go", "mod", "verify").Execute(ctx, nil, os.Stdout, os.Stderr); err != nil {
+		return fmt.Errorf("unable to verify modules: %w", err)
+	}
+	return nil
+}
+
+// vulnMessage is a line of `govulncheck -json` output.  Only the parts VulnCheck uses are decoded
+type vulnMessage struct {
+	OSV *struct {
+		ID      string   `json:"id"`
+		Summary string   `json:"summary"`
+		Aliases []string `json:"aliases"`
+	} `json:"osv"`
+	Finding *vulnFinding `json:"finding"`
+}
+
+type vulnFinding struct {
+	OSV          string `json:"osv"`
+	FixedVersion string `json:"fixed_version"`
+	Trace        []struct {
+		Module   string `json:"module"`
+		Version  string `json:"version"`
+		Package  string `json:"package"`
+		Function string `json:"function"`
+		Receiver string `json:"receiver"`
+		Position *struct {
+			Filename string `json:"filename"`
+			Line     int    `json:"line"`
+		} `json:"position"`
+	} `json:"trace"`
+}
+
+// vulnerability is everything govulncheck found about one OSV entry
+type vulnerability struct {
+	ID           string
+	Summary      string
+	Aliases      []string
+	Module       string
+	Version      string
+	FixedVersion string
+	// Called is set when the vulnerable code is reachable from the code being checked
+	Called bool
+	// Locations are where the code being checked calls into the vulnerable code
+	Locations []string
+}
+
+// parseVulnCheck reads `govulncheck -json` output and returns the vulnerabilities that affect the module, sorted by ID
+func parseVulnCheck(r io.Reader) ([]*vulnerability, error) {
+	byID := make(map[string]*vulnerability)
+	get := func(id string) *vulnerability {
+		if v, exists := byID[id]; exists {
+			return v
+		}
+		v := &vulnerability{ID: id}
+		byID[id] = v
+		return v
+	}
+	dec := json.NewDecoder(r)
+	for {
+		var msg vulnMessage
+		if err := dec.Decode(&msg); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, fmt.Errorf("unable to decode govulncheck output: %w", err)
+		}
+		if msg.OSV != nil {
+			// Entries without findings do not affect the module, and are dropped below
+			v := get(msg.OSV.ID)
+			v.Summary = msg.OSV.Summary
+			v.Aliases = msg.OSV.Aliases
+		}
+		if msg.Finding == nil {
+			continue
+		}
+		v := get(msg.Finding.OSV)
+		v.FixedVersion = msg.Finding.FixedVersion
+		if len(msg.Finding.Trace) == 0 {
+			continue
+		}
+		vulnerable := msg.Finding.Trace[0]
+		v.Module, v.Version = vulnerable.Module, vulnerable.Version
+		if vulnerable.Function == "" {
+			continue
+		}
+		v.Called = true
+		// The last frame with a position is the call in the code being checked
+		for i := len(msg.Finding.Trace) - 1; i >= 0; i-- {
+			if pos := msg.Finding.Trace[i].Position; pos != nil {
+				v.Locations = append(v.Locations, fmt.Sprintf("%s:%d", pos.Filename, pos.Line))
+				break
+			}
+		}
+	}
+	ret := make([]*vulnerability, 0, len(byID))
+	for _, v := range byID {
+		if v.Module != "" {
+			ret = append(ret, v)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].ID < ret[j].ID
+	})
+	return ret, nil
+}
+
+// readVulnAllowlist reads accepted vulnerability IDs from path: one per line, optionally followed by a reason.
+// Lines starting with # are comments.  A missing file allows nothing
+func readVulnAllowlist(path string) (map[string]bool, error) {
+	ret := make(map[string]bool)
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return ret, nil
+		}
+		return nil, fmt.Errorf("unable to read vulnerability allowlist: %w", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		ret[fields[0]] = true
+	}
+	return ret, scanner.Err()
+}
+
+func (v *vulnerability) allowed(allowlist map[string]bool) bool {
+	if allowlist[v.ID] {
+		return true
+	}
+	for _, alias := range v.Aliases {
+		if allowlist[alias] {
+			return true
+		}
+	}
+	return false
+}
+
+// printVulnerabilities writes a summary of vulns to w and returns the ones that fail the check: called and not allowed
+func printVulnerabilities(w io.Writer, vulns []*vulnerability, allowlist map[string]bool) []string {
+	var failed []string
+	var imported int
+	for _, v := range vulns {
+		if !v.Called {
+			imported++
+			continue
+		}
+		status := ""
+		if v.allowed(allowlist) {
+			status = " (allowed)"
+		} else {
+			failed = append(failed, v.ID)
+		}
+		fixed := "no fix available"
+		if v.FixedVersion != "" {
+			fixed = "fixed in " + v.FixedVersion
+		}
+		fmt.Fprintf(w, "%s%s: %s\n  %s@%s, %s\n", v.ID, status, v.Summary, v.Module, v.Version, fixed)
+		for _, loc := range v.Locations {
+			fmt.Fprintf(w, "  called from %s\n", loc)
+		}
+	}
+	if imported > 0 {
+		fmt.Fprintf(w, "%d more vulnerabilities are in dependencies, but the vulnerable code is not called\n", imported)
+	}
+	return failed
+}
+
+// VulnCheck runs govulncheck and fails if the code calls vulnerable code that is not in the allowlist file
+// ${GO_VULN_ALLOWLIST}, .govulncheck-allow by default
+func (g *Go) VulnCheck(ctx context.Context) error {
+	if err := tools.Require(ctx, "govulncheck"); err != nil {
+		return err
+	}
+	var out bytes.Buffer
+	if err := pipe.NewPiped("govulncheck", "-json", "./...").Execute(ctx, nil, &out, os.Stderr); err != nil {
+		return fmt.Errorf("unable to run govulncheck: %w", err)
+	}
+	vulns, err := parseVulnCheck(&out)
+	if err != nil {
+		return err
+	}
+	allowlist, err := readVulnAllowlist(g.Env.GetDefault("GO_VULN_ALLOWLIST", ".govulncheck-allow"))
+	if err != nil {
+		return err
+	}
+	if failed := printVulnerabilities(os.Stdout, vulns, allowlist); len(failed) > 0 {
+		return fmt.Errorf("found %d vulnerabilities: %s", len(failed), strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// ModCheck runs ModTidyCheck, ModVerify and VulnCheck
+func (g *Go) ModCheck(ctx context.Context) error {
+	if !files.FileExists("go.mod") {
+		return fmt.Errorf("no go.mod in the current directory")
+	}
+	if err := g.ModTidyCheck(ctx); err != nil {
+		return err
+	}
+	if err := g.ModVerify(ctx); err != nil {
+		return err
+	}
+	return g.VulnCheck(ctx)
+}
@@ -0,0 +1,36 @@
+package gobuild
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const govulncheckJSON = `{"config":{"protocol_version":"v1.0.0","scanner_name":"govulncheck"}}
+{"osv":{"id":"GO-2024-0001","summary":"Crash in Parse","aliases":["CVE-2024-1111"]}}
+{"osv":{"id":"GO-2024-0002","summary":"Leak in Dial"}}
+{"osv":{"id":"GO-2024-0003","summary":"Not used at all"}}
+{"finding":{"osv":"GO-2024-0001","fixed_version":"v1.2.3","trace":[{"module":"example.com/dep","version":"v1.2.0"}]}}
+{"finding":{"osv":"GO-2024-0001","fixed_version":"v1.2.3","trace":[{"module":"example.com/dep","version":"v1.2.0","package":"example.com/dep","function":"Parse"},{"module":"example.com/app","package":"example.com/app","function":"main","position":{"filename":"main.go","line":12}}]}}
+{"finding":{"osv":"GO-2024-0002","trace":[{"module":"example.com/net","version":"v0.1.0","package":"example.com/net"}]}}
+`
+
+func TestVulnCheck(t *testing.T) {
+	vulns, err := parseVulnCheck(strings.NewReader(govulncheckJSON))
+	require.NoError(t, err)
+	require.Len(t, vulns, 2)
+	require.True(t, vulns[0].Called)
+	require.Equal(t, []string{"main.go:12"}, vulns[0].Locations)
+	require.False(t, vulns[1].Called)
+
+	var out bytes.Buffer
+	require.Equal(t, []string{"GO-2024-0001"}, printVulnerabilities(&out, vulns, map[string]bool{}))
+	require.Equal(t, `GO-2024-0001: Crash in Parse
+  example.com/dep@v1.2.0, fixed in v1.2.3
+  called from main.go:12
+1 more vulnerabilities are in dependencies, but the vulnerable code is not called
+`, out.String())
+	require.Empty(t, printVulnerabilities(&out, vulns, map[string]bool{"CVE-2024-1111": true}))
+}
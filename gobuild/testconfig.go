@@ -0,0 +1,183 @@
+package gobuild
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/cresta/magehelper/pipe"
+)
+
+// TestHook brings up, or tears down, something tests depend on
+type TestHook func(ctx context.Context) error
+
+// TestConfig configures TestWithConfig and IntegrationTestWithConfig.  Test and IntegrationTest read the defaults
+// of each field from different environment variables, named in the comments as TEST/INTEGRATION.
+type TestConfig struct {
+	// Tags are build tags.  Defaults to ${GO_TEST_TAGS}/${GO_INTEGRATION_TAGS}, or integration for IntegrationTest
+	Tags []string
+	// Run only runs tests and benchmarks matching the regular expression, like go test -run.  Defaults to
+	// ${GO_TEST_RUN}/${GO_INTEGRATION_RUN}
+	Run string
+	// Packages to test.  Defaults to ${GO_TEST_PACKAGES}/${GO_INTEGRATION_PACKAGES}, or ./...
+	Packages []string
+	// CoverProfile is where a coverage profile is written.  Defaults to ${GO_COVERAGE}/${GO_INTEGRATION_COVERAGE}
+	CoverProfile string
+	// JUnitReport is where a JUnit XML report is written.  Defaults to ${GO_JUNIT_REPORT}/${GO_INTEGRATION_JUNIT_REPORT}
+	JUnitReport string
+	// Setup runs in order before the tests.  Defaults to DockerCompose of ${GO_TEST_COMPOSE}/${GO_INTEGRATION_COMPOSE}
+	// when set
+	Setup []TestHook
+	// Teardown runs in reverse order after the tests, even when Setup or the tests fail or ctx is canceled
+	Teardown []TestHook
+	// TeardownTimeout limits how long Teardown may take.  Defaults to 5 minutes
+	TeardownTimeout time.Duration
+}
+
+// splitList splits s on commas and whitespace
+func splitList(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+}
+
+// withTestDefaults fills config from environment variables starting with prefix, GO_TEST or GO_INTEGRATION
+func (g *Go) withTestDefaults(config TestConfig, prefix string) TestConfig {
+	if config.Tags == nil {
+		config.Tags = splitList(g.Env.Get(prefix + "_TAGS"))
+	}
+	if config.Run == "" {
+		config.Run = g.Env.Get(prefix + "_RUN")
+	}
+	if len(config.Packages) == 0 {
+		config.Packages = splitList(g.Env.Get(prefix + "_PACKAGES"))
+	}
+	if config.Setup == nil && config.Teardown == nil {
+		if composeFiles := splitList(g.Env.Get(prefix + "_COMPOSE")); len(composeFiles) > 0 {
+			setup, teardown := DockerCompose(composeFiles...)
+			config.Setup = []TestHook{setup}
+			config.Teardown = []TestHook{teardown}
+		}
+	}
+	return config
+}
+
+// args returns the go test flags for config, without the packages
+func (c *TestConfig) args() []string {
+	bench := "."
+	if c.Run != "" {
+		bench = c.Run
+	}
+	ret := []string{"-race", "-benchtime", "1ns", "-bench", bench}
+	if len(c.Tags) > 0 {
+		ret = append(ret, "-tags", strings.Join(c.Tags, ","))
+	}
+	if c.Run != "" {
+		ret = append(ret, "-run", c.Run)
+	}
+	if c.CoverProfile != "" {
+		ret = append(ret, "-coverprofile", c.CoverProfile)
+	}
+	return ret
+}
+
+// rerunArgs returns the go test flags that rerun the tests matching pattern in pkg
+func (c *TestConfig) rerunArgs(pattern string, pkg string) []string {
+	ret := []string{"-race", "-count=1"}
+	if len(c.Tags) > 0 {
+		ret = append(ret, "-tags", strings.Join(c.Tags, ","))
+	}
+	return append(ret, "-run", pattern, pkg)
+}
+
+// withHooks runs the Setup hooks, then fn, then the Teardown hooks.  Teardown always runs, on a context that is not
+// canceled with ctx, so dependencies are cleaned up when the tests are interrupted.
+func (c *TestConfig) withHooks(ctx context.Context, fn func(ctx context.Context) error) (retErr error) {
+	defer func() {
+		timeout := c.TeardownTimeout
+		if timeout <= 0 {
+			timeout = 5 * time.Minute
+		}
+		teardownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+		defer cancel()
+		for i := len(c.Teardown) - 1; i >= 0; i-- {
+			if err := c.Teardown[i](teardownCtx); err != nil {
+				retErr = errors.Join(retErr, fmt.Errorf("unable to tear down test dependencies: %w", err))
+			}
+		}
+	}()
+	for _, setup := range c.Setup {
+		if err := setup(ctx); err != nil {
+			return fmt.Errorf("unable to set up test dependencies: %w", err)
+		}
+	}
+	return fn(ctx)
+}
+
+// DockerCompose returns hooks that start the services of the compose files with `docker compose up -d --wait`, and
+// remove them and their volumes afterwards.
+func DockerCompose(files ...string) (setup TestHook, teardown TestHook) {
+	var args []string
+	for _, f := range files {
+		args = append(args, "-f", f)
+	}
+	compose := func(extra ...string) *pipe.PipedCmd {
+		return pipe.NewPiped("docker", append(append([]string{"compose"}, args...), extra...)...)
+	}
+	setup = func(ctx context.Context) error {
+		return compose("up", "-d", "--wait").Execute(ctx, nil, os.Stdout, os.Stderr)
+	}
+	teardown = func(ctx context.Context) error {
+		return compose("down", "--volumes", "--remove-orphans").Execute(ctx, nil, os.Stdout, os.Stderr)
+	}
+	return setup, teardown
+}
+
+// TestWithConfig runs the tests of config.  See Test
+func (g *Go) TestWithConfig(ctx context.Context, config TestConfig) error {
+	config = g.withTestDefaults(config, "GO_TEST")
+	if config.CoverProfile == "" {
+		config.CoverProfile = g.Env.Get("GO_COVERAGE")
+	}
+	if config.JUnitReport == "" {
+		config.JUnitReport = g.Env.Get("GO_JUNIT_REPORT")
+	}
+	if len(config.Packages) == 0 {
+		packages, err := g.testPackages(ctx)
+		if err != nil {
+			return err
+		}
+		if len(packages) == 0 {
+			fmt.Println("No packages affected by changed files")
+			return nil
+		}
+		config.Packages = packages
+	}
+	return config.withHooks(ctx, func(ctx context.Context) error {
+		return g.runTests(ctx, config)
+	})
+}
+
+// IntegrationTestWithConfig runs the integration tests of config.  See IntegrationTest
+func (g *Go) IntegrationTestWithConfig(ctx context.Context, config TestConfig) error {
+	config = g.withTestDefaults(config, "GO_INTEGRATION")
+	if len(config.Tags) == 0 {
+		config.Tags = []string{"integration"}
+	}
+	if len(config.Packages) == 0 {
+		config.Packages = []string{"./..."}
+	}
+	if config.CoverProfile == "" {
+		config.CoverProfile = g.Env.Get("GO_INTEGRATION_COVERAGE")
+	}
+	if config.JUnitReport == "" {
+		config.JUnitReport = g.Env.Get("GO_INTEGRATION_JUNIT_REPORT")
+	}
+	return config.withHooks(ctx, func(ctx context.Context) error {
+		return g.runTests(ctx, config)
+	})
+}
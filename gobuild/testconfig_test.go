@@ -0,0 +1,54 @@
+package gobuild
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/cresta/magehelper/env"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIntegrationTestDefaults(t *testing.T) {
+	g := Go{Env: *env.NewFromMap(map[string]string{
+		"GO_INTEGRATION_TAGS": "integration, e2e",
+		"GO_INTEGRATION_RUN":  "TestDB",
+	})}
+	config := g.withTestDefaults(TestConfig{}, "GO_INTEGRATION")
+	require.Equal(t, []string{"-race", "-benchtime", "1ns", "-bench", "TestDB", "-tags", "integration,e2e", "-run", "TestDB"}, config.args())
+	require.Empty(t, config.Setup)
+}
+
+func TestTestConfig_withHooks(t *testing.T) {
+	var calls []string
+	hook := func(name string, err error) TestHook {
+		return func(ctx context.Context) error {
+			calls = append(calls, name)
+			return err
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	config := TestConfig{
+		Setup:    []TestHook{hook("up a", nil), hook("up b", errors.New("bad"))},
+		Teardown: []TestHook{hook("down a", nil), hook("down b", nil)},
+	}
+	err := config.withHooks(ctx, func(ctx context.Context) error {
+		calls = append(calls, "test")
+		return nil
+	})
+	require.EqualError(t, err, "unable to set up test dependencies: bad")
+	require.Equal(t, []string{"up a", "up b", "down b", "down a"}, calls)
+
+	calls = nil
+	config.Setup = nil
+	config.Teardown = []TestHook{func(ctx context.Context) error {
+		calls = append(calls, "down")
+		return ctx.Err()
+	}}
+	err = config.withHooks(ctx, func(ctx context.Context) error {
+		cancel()
+		return ctx.Err()
+	})
+	require.Equal(t, context.Canceled, err)
+	require.Equal(t, []string{"down"}, calls)
+}
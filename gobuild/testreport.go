@@ -0,0 +1,471 @@
+package gobuild
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cresta/magehelper/cicd"
+	"github.com/cresta/magehelper/pipe"
+)
+
+// testEvent is a line of `go test -json` output.  See `go doc cmd/test2json`
+type testEvent struct {
+	Action  string
+	Package string
+	// ImportPath is set instead of Package for build-output events
+	ImportPath string
+	Test       string
+	Elapsed    float64
+	Output     string
+}
+
+// testResult is the outcome of a test, or of a whole package when Test is empty
+type testResult struct {
+	Package string
+	Test    string
+	// Status is pass, fail or skip.  Empty if the test never finished, like when the binary panics
+	Status  string
+	Elapsed time.Duration
+	Output  strings.Builder
+	// Flaky is set when the test failed, then passed when rerun.  FlakyOutput is the output of the failure
+	Flaky       bool
+	FlakyOutput string
+}
+
+func (r *testResult) name() string {
+	if r.Test == "" {
+		return r.Package
+	}
+	return r.Package + "." + r.Test
+}
+
+func (r *testResult) failed() bool {
+	return r.Status == "fail" || r.Status == ""
+}
+
+type testKey struct {
+	pkg  string
+	test string
+}
+
+// testReport collects the results of one or more `go test -json` runs
+type testReport struct {
+	results map[testKey]*testResult
+	order   []testKey
+}
+
+func newTestReport() *testReport {
+	return &testReport{results: make(map[testKey]*testResult)}
+}
+
+func (t *testReport) result(pkg string, test string) *testResult {
+	key := testKey{pkg: pkg, test: test}
+	if r, exists := t.results[key]; exists {
+		return r
+	}
+	r := &testResult{Package: pkg, Test: test}
+	t.results[key] = r
+	t.order = append(t.order, key)
+	return r
+}
+
+// parse reads `go test -json` output from r.  Output that is not about a single test, like the ok/FAIL line of each
+// package or build errors, is copied to w so progress is still visible.
+func (t *testReport) parse(r io.Reader, w io.Writer) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		var ev testEvent
+		if len(line) == 0 || line[0] != '{' || json.Unmarshal(line, &ev) != nil {
+			if _, err := fmt.Fprintf(w, "%s\n", line); err != nil {
+				return err
+			}
+			continue
+		}
+		switch ev.Action {
+		case "build-output":
+			if _, err := io.WriteString(w, ev.Output); err != nil {
+				return err
+			}
+		case "output":
+			res := t.result(ev.Package, ev.Test)
+			res.Output.WriteString(ev.Output)
+			if ev.Test == "" {
+				if _, err := io.WriteString(w, ev.Output); err != nil {
+					return err
+				}
+			}
+		case "run":
+			t.result(ev.Package, ev.Test)
+		case "pass", "fail", "skip":
+			res := t.result(ev.Package, ev.Test)
+			res.Status = ev.Action
+			res.Elapsed = time.Duration(ev.Elapsed * float64(time.Second))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("unable to read go test output: %w", err)
+	}
+	return nil
+}
+
+// merge applies the results of a rerun.  Anything that failed before and passed in the rerun is flaky.
+func (t *testReport) merge(rerun *testReport) {
+	for _, key := range rerun.order {
+		orig, exists := t.results[key]
+		res := rerun.results[key]
+		if !exists || !orig.failed() || res.Status != "pass" {
+			continue
+		}
+		orig.FlakyOutput = orig.Output.String()
+		orig.Output.Reset()
+		orig.Output.WriteString(res.Output.String())
+		orig.Status = "pass"
+		orig.Flaky = true
+	}
+}
+
+// failures returns the tests that failed.  A test that failed because one of its subtests did is left out, as is a
+// package that failed because one of its tests did.
+func (t *testReport) failures() []*testResult {
+	var ret []*testResult
+	for _, key := range t.order {
+		res := t.results[key]
+		if !res.failed() || (res.Test == "" && res.Status == "") || t.hasFailedChild(key) {
+			continue
+		}
+		ret = append(ret, res)
+	}
+	return ret
+}
+
+func (t *testReport) hasFailedChild(parent testKey) bool {
+	for _, key := range t.order {
+		if key.pkg != parent.pkg || key == parent || !t.results[key].failed() {
+			continue
+		}
+		if parent.test == "" || strings.HasPrefix(key.test, parent.test+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *testReport) filter(keep func(r *testResult) bool) []*testResult {
+	var ret []*testResult
+	for _, key := range t.order {
+		if res := t.results[key]; res.Test != "" && keep(res) {
+			ret = append(ret, res)
+		}
+	}
+	return ret
+}
+
+// rerunPatterns returns, for each package with failed tests, a -run pattern matching the top level failed tests.
+// Packages that failed outside a test, like when they do not build, are not returned since a rerun cannot help.
+func (t *testReport) rerunPatterns() (map[string]string, bool) {
+	tests := make(map[string][]string)
+	for _, res := range t.failures() {
+		if res.Test == "" {
+			return nil, false
+		}
+		top, _, _ := strings.Cut(res.Test, "/")
+		tests[res.Package] = append(tests[res.Package], regexp.QuoteMeta(top))
+	}
+	ret := make(map[string]string, len(tests))
+	for pkg, names := range tests {
+		sort.Strings(names)
+		ret[pkg] = "^(" + strings.Join(names, "|") + ")$"
+	}
+	return ret, true
+}
+
+// testOutput is the output of a failed test without the === RUN lines go test adds
+func testOutput(output string) string {
+	var sb strings.Builder
+	for _, line := range strings.SplitAfter(output, "\n") {
+		if !strings.HasPrefix(strings.TrimSpace(line), "=== ") {
+			sb.WriteString(line)
+		}
+	}
+	return sb.String()
+}
+
+// printSummary writes the failed, flaky, skipped and slow tests to w
+func (t *testReport) printSummary(w io.Writer, slow time.Duration) {
+	skipped := t.filter(func(r *testResult) bool { return r.Status == "skip" })
+	if len(skipped) > 0 {
+		fmt.Fprintf(w, "\nSkipped %d tests:\n", len(skipped))
+		for _, res := range skipped {
+			fmt.Fprintf(w, "  %s\n", res.name())
+		}
+	}
+	slowTests := t.filter(func(r *testResult) bool { return !strings.Contains(r.Test, "/") && r.Elapsed >= slow })
+	sort.SliceStable(slowTests, func(i, j int) bool {
+		return slowTests[i].Elapsed > slowTests[j].Elapsed
+	})
+	if len(slowTests) > 0 {
+		fmt.Fprintf(w, "\nSlow tests (over %s):\n", slow)
+		for _, res := range slowTests {
+			fmt.Fprintf(w, "  %8s %s\n", res.Elapsed.Round(time.Millisecond), res.name())
+		}
+	}
+	flaky := t.filter(func(r *testResult) bool { return r.Flaky })
+	if len(flaky) > 0 {
+		fmt.Fprintf(w, "\nFlaky tests (failed, then passed when rerun):\n")
+		for _, res := range flaky {
+			fmt.Fprintf(w, "  %s\n", res.name())
+		}
+	}
+	failures := t.failures()
+	if len(failures) > 0 {
+		fmt.Fprintf(w, "\nFailed tests:\n")
+		for _, res := range failures {
+			fmt.Fprintf(w, "--- FAIL: %s\n%s", res.name(), testOutput(res.Output.String()))
+		}
+	}
+}
+
+var testLocation = regexp.MustCompile(`(?m)^\s+([\w.\-]+\.go):(\d+):`)
+
+// annotate reports each failure to the CI backend, at the line of the first test log message if there is one.  Files
+// are relative to root, the root of the repository
+func (t *testReport) annotate(ctx context.Context, annotator cicd.Annotator, root string) {
+	failures := t.failures()
+	if len(failures) == 0 {
+		return
+	}
+	var packages []string
+	for _, res := range failures {
+		packages = append(packages, res.Package)
+	}
+	dirs := packageDirs(ctx, packages)
+	for _, res := range failures {
+		annotation := cicd.Annotation{
+			Title:   "Failed: " + res.name(),
+			Message: testOutput(res.Output.String()),
+		}
+		if m := testLocation.FindStringSubmatch(res.Output.String()); m != nil && dirs[res.Package] != "" {
+			if rel, err := filepath.Rel(root, filepath.Join(dirs[res.Package], m[1])); err == nil {
+				annotation.File = filepath.ToSlash(rel)
+				annotation.Line, _ = strconv.Atoi(m[2])
+			}
+		}
+		annotator.AnnotateError(annotation)
+	}
+}
+
+// packageDirs returns the directory of each of the packages, or nothing if go list fails
+func packageDirs(ctx context.Context, packages []string) map[string]string {
+	ret := make(map[string]string)
+	if len(packages) == 0 {
+		return ret
+	}
+	var out bytes.Buffer
+	args := append([]string{"list", "-e", "-f", "{{.ImportPath}} {{.Dir}}"}, packages...)
+	if err := pipe.NewPiped("go", args...).ReadOnly().Execute(ctx, nil, &out, io.Discard); err != nil {
+		return ret
+	}
+	for _, line := range strings.Split(out.String(), "\n") {
+		if pkg, dir, found := strings.Cut(line, " "); found {
+			ret[pkg] = dir
+		}
+	}
+	return ret
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Skipped  int             `xml:"skipped,attr"`
+	Time     string          `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string         `xml:"name,attr"`
+	Classname string         `xml:"classname,attr"`
+	Time      string         `xml:"time,attr"`
+	Failure   *junitMessage  `xml:"failure,omitempty"`
+	Skipped   *junitMessage  `xml:"skipped,omitempty"`
+	Flaky     []junitMessage `xml:"flakyFailure,omitempty"`
+	SystemOut string         `xml:"system-out,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+func junitSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
+
+// writeJUnit writes the report as JUnit XML.  Flaky tests pass, with the failure recorded as a flakyFailure, like
+// the maven surefire plugin does.
+func (t *testReport) writeJUnit(w io.Writer) error {
+	var ret junitTestSuites
+	suites := make(map[string]*junitTestSuite)
+	var names []string
+	for _, key := range t.order {
+		res := t.results[key]
+		suite, exists := suites[res.Package]
+		if !exists {
+			suite = &junitTestSuite{Name: res.Package}
+			suites[res.Package] = suite
+			names = append(names, res.Package)
+		}
+		if res.Test == "" {
+			suite.Time = junitSeconds(res.Elapsed)
+			if !res.failed() || res.Status == "" || t.hasFailedChild(key) {
+				continue
+			}
+		}
+		tc := junitTestCase{
+			Name:      res.Test,
+			Classname: res.Package,
+			Time:      junitSeconds(res.Elapsed),
+		}
+		output := testOutput(res.Output.String())
+		switch {
+		case res.Test == "":
+			tc.Name = "(package)"
+			tc.Failure = &junitMessage{Message: "Package failed", Text: output}
+		case res.failed():
+			tc.Failure = &junitMessage{Message: "Failed", Text: output}
+		case res.Status == "skip":
+			tc.Skipped = &junitMessage{Message: "Skipped", Text: output}
+		case res.Flaky:
+			tc.Flaky = []junitMessage{{Message: "Failed before passing on rerun", Text: testOutput(res.FlakyOutput)}}
+			tc.SystemOut = output
+		}
+		suite.Tests++
+		if tc.Failure != nil {
+			suite.Failures++
+		}
+		if tc.Skipped != nil {
+			suite.Skipped++
+		}
+		suite.Cases = append(suite.Cases, tc)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		suite := suites[name]
+		if len(suite.Cases) == 0 {
+			continue
+		}
+		ret.Tests += suite.Tests
+		ret.Failures += suite.Failures
+		ret.Skipped += suite.Skipped
+		ret.Suites = append(ret.Suites, *suite)
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(ret); err != nil {
+		return fmt.Errorf("unable to encode junit report: %w", err)
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// runTestJSON runs go with args, which should include -json, and parses its output while it runs
+func (g *Go) runTestJSON(ctx context.Context, args []string) (*testReport, error) {
+	report := newTestReport()
+	pr, pw := io.Pipe()
+	parsed := make(chan error, 1)
+	go func() {
+		err := report.parse(pr, os.Stdout)
+		_, _ = io.Copy(io.Discard, pr)
+		parsed <- err
+	}()
+	err := pipe.NewPiped("go", args...).
+		WithEnv(g.Env.AddEnv("GORACE=halt_on_error=1")).
+		Execute(ctx, nil, pw, os.Stderr)
+	_ = pw.Close()
+	if parseErr := <-parsed; parseErr != nil {
+		return report, parseErr
+	}
+	return report, err
+}
+
+// flakyReruns is how many times failed tests are rerun before they count as failed, from ${GO_TEST_FLAKY_RERUNS}
+func (g *Go) flakyReruns() int {
+	if n, err := strconv.Atoi(g.Env.Get("GO_TEST_FLAKY_RERUNS")); err == nil && n > 0 {
+		return n
+	}
+	return 0
+}
+
+// slowTestThreshold is how long a test runs before the summary lists it, from ${GO_TEST_SLOW}.  Defaults to 5s
+func (g *Go) slowTestThreshold() time.Duration {
+	if d, err := time.ParseDuration(g.Env.Get("GO_TEST_SLOW")); err == nil && d > 0 {
+		return d
+	}
+	return 5 * time.Second
+}
+
+// runTests runs `go test -json` for config, reruns failed tests if requested, then prints a summary, writes the
+// JUnit report and reports failures to the CI backend.
+func (g *Go) runTests(ctx context.Context, config TestConfig) error {
+	report, runErr := g.runTestJSON(ctx, append(append([]string{"test", "-json"}, config.args()...), config.Packages...))
+	for i := 0; i < g.flakyReruns() && ctx.Err() == nil && len(report.failures()) > 0; i++ {
+		patterns, ok := report.rerunPatterns()
+		if !ok {
+			break
+		}
+		for pkg, pattern := range patterns {
+			fmt.Printf("Rerunning failed tests in %s (%d/%d)\n", pkg, i+1, g.flakyReruns())
+			rerun, _ := g.runTestJSON(ctx, append([]string{"test", "-json"}, config.rerunArgs(pattern, pkg)...))
+			report.merge(rerun)
+		}
+	}
+	report.printSummary(os.Stdout, g.slowTestThreshold())
+	if config.JUnitReport != "" {
+		var buf bytes.Buffer
+		if err := report.writeJUnit(&buf); err != nil {
+			return err
+		}
+		if err := os.WriteFile(config.JUnitReport, buf.Bytes(), 0o600); err != nil {
+			return fmt.Errorf("unable to write junit report %s: %w", config.JUnitReport, err)
+		}
+	}
+	if annotator, ok := g.cicd().(cicd.Annotator); ok {
+		root, err := g.git().TopLevel(ctx)
+		if err != nil {
+			root, _ = os.Getwd()
+		}
+		report.annotate(ctx, annotator, root)
+	}
+	if failures := report.failures(); len(failures) > 0 {
+		return fmt.Errorf("%d tests failed", len(failures))
+	}
+	if runErr != nil && len(report.filter(func(r *testResult) bool { return r.Flaky })) == 0 {
+		return runErr
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package gobuild
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cresta/magehelper/cicd"
+	"github.com/cresta/magehelper/pipe"
+	"github.com/stretchr/testify/require"
+)
+
+const testJSON = `{"Action":"run","Package":"example.com/a","Test":"TestOK"}
+{"Action":"output","Package":"example.com/a","Test":"TestOK","Output":"=== RUN   TestOK\n"}
+{"Action":"pass","Package":"example.com/a","Test":"TestOK","Elapsed":6}
+{"Action":"run","Package":"example.com/a","Test":"TestSkip"}
+{"Action":"skip","Package":"example.com/a","Test":"TestSkip"}
+{"Action":"run","Package":"example.com/a","Test":"TestBad"}
+{"Action":"run","Package":"example.com/a","Test":"TestBad/sub"}
+{"Action":"output","Package":"example.com/a","Test":"TestBad/sub","Output":"    a_test.go:12: broken\n"}
+{"Action":"fail","Package":"example.com/a","Test":"TestBad/sub","Elapsed":0.1}
+{"Action":"fail","Package":"example.com/a","Test":"TestBad","Elapsed":0.1}
+{"Action":"output","Package":"example.com/a","Output":"FAIL\texample.com/a\t6.2s\n"}
+{"Action":"fail","Package":"example.com/a","Elapsed":6.2}
+`
+
+func parseTestJSON(t *testing.T, in string) (*testReport, string) {
+	report := newTestReport()
+	var out bytes.Buffer
+	require.NoError(t, report.parse(strings.NewReader(in), &out))
+	return report, out.String()
+}
+
+func TestTestReport(t *testing.T) {
+	report, out := parseTestJSON(t, testJSON)
+	require.Equal(t, "FAIL\texample.com/a\t6.2s\n", out)
+	failures := report.failures()
+	require.Len(t, failures, 1)
+	require.Equal(t, "example.com/a.TestBad/sub", failures[0].name())
+	patterns, ok := report.rerunPatterns()
+	require.True(t, ok)
+	require.Equal(t, map[string]string{"example.com/a": "^(TestBad)$"}, patterns)
+
+	var summary bytes.Buffer
+	report.printSummary(&summary, 5*time.Second)
+	require.Contains(t, summary.String(), "Skipped 1 tests:\n  example.com/a.TestSkip\n")
+	require.Contains(t, summary.String(), "6s example.com/a.TestOK\n")
+	require.Contains(t, summary.String(), "--- FAIL: example.com/a.TestBad/sub\n    a_test.go:12: broken\n")
+
+	var junit bytes.Buffer
+	require.NoError(t, report.writeJUnit(&junit))
+	require.Contains(t, junit.String(), `<testsuite name="example.com/a" tests="4" failures="2" skipped="1" time="6.200">`)
+
+	rerun, _ := parseTestJSON(t, `{"Action":"pass","Package":"example.com/a","Test":"TestBad/sub"}
+{"Action":"pass","Package":"example.com/a","Test":"TestBad"}
+{"Action":"pass","Package":"example.com/a"}
+`)
+	report.merge(rerun)
+	require.Empty(t, report.failures())
+	junit.Reset()
+	require.NoError(t, report.writeJUnit(&junit))
+	require.Contains(t, junit.String(), `<testsuite name="example.com/a" tests="4" failures="0" skipped="1" time="6.200">`)
+	require.Contains(t, junit.String(), `<flakyFailure message="Failed before passing on rerun">    a_test.go:12: broken&#xA;</flakyFailure>`)
+}
+
+type annotations []cicd.Annotation
+
+func (a *annotations) AnnotateError(annotation cicd.Annotation) {
+	*a = append(*a, annotation)
+}
+
+func TestTestReport_annotate(t *testing.T) {
+	root := t.TempDir()
+	rec := &pipe.Recording{
+		Stub: func(pipeline []pipe.Command, _ io.Reader, stdout io.Writer) error {
+			_, err := io.WriteString(stdout, "example.com/a "+filepath.Join(root, "sub", "a")+"\n")
+			return err
+		},
+	}
+	defer pipe.SetRecorder(rec)()
+	report, _ := parseTestJSON(t, testJSON)
+	var got annotations
+	report.annotate(context.Background(), &got, root)
+	require.Len(t, got, 1)
+	// Relative to the repository root, wherever mage runs
+	require.Equal(t, "sub/a/a_test.go", got[0].File)
+	require.Equal(t, 12, got[0].Line)
+	require.Equal(t, "Failed: example.com/a.TestBad/sub", got[0].Title)
+}
@@ -1,14 +1,14 @@
 package kustomize
 
 import (
-	"bytes"
 	"context"
 	"fmt"
-	"os/exec"
 	"path/filepath"
 	"regexp"
 
 	"github.com/cresta/magehelper/files"
+	"github.com/cresta/magehelper/pipe"
+	"github.com/cresta/magehelper/tools"
 	"gopkg.in/yaml.v3"
 )
 
@@ -48,13 +48,16 @@ func (m *Manifest) LongName() string {
 //
 // The returned map maps the Manifest long name to the manifest.
 func Init(ctx context.Context, path string) (map[string]Manifest, error) {
+	if err := tools.Require(ctx, "kustomize"); err != nil {
+		return nil, err
+	}
 	if !files.FileExists(filepath.Join(path, "kustomization.yaml")) {
-		_, err := shell(path, "kustomize", "init", ".", "--recursive", "--autodetect")
+		_, err := pipe.NewPiped("kustomize", "init", ".", "--recursive", "--autodetect").WithDir(path).Output(ctx)
 		if err != nil {
 			return nil, fmt.Errorf("cannot init kustomize %s: %w", path, err)
 		}
 	}
-	output, err := shell(path, "kustomize", "build", "--load-restrictor=LoadRestrictionsNone")
+	output, err := pipe.NewPiped("kustomize", "build", "--load-restrictor=LoadRestrictionsNone").WithDir(path).ReadOnly().Output(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to run kustomize at %s: %w", path, err)
 	}
@@ -98,18 +101,6 @@ func Init(ctx context.Context, path string) (map[string]Manifest, error) {
 	return documents, nil
 }
 
-func shell(pwd string, cmdName string, args ...string) (stdout string, err error) {
-	cmd := exec.Command(cmdName, args...)
-	cmd.Dir = pwd
-	stderr := bytes.NewBuffer(nil)
-	cmd.Stderr = stderr
-	output, err := cmd.Output()
-	if err != nil {
-		return "", fmt.Errorf("%w. Stderr: %s", err, stderr.String())
-	}
-	return string(output), nil
-}
-
 func getString(m map[string]any, verbose bool, keys ...string) (string, bool) {
 	valueAny, ok := getValue(m, verbose, keys...)
 	if !ok {
@@ -0,0 +1,120 @@
+package pipe
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StderrTailSize is how many bytes at the end of stderr an ExitError keeps
+var StderrTailSize = 4 << 10
+
+// ExitError is returned by Execute when a command of the pipeline fails
+type ExitError struct {
+	// Args is the command line of the failing command, starting with the program
+	Args []string
+	// Stage is the position of the command in the pipeline, starting at 0, and Stages the number of commands in it
+	Stage  int
+	Stages int
+	// ExitCode is the exit status of the command, or -1 if it was killed by a signal
+	ExitCode int
+	Duration time.Duration
+	// TimedOut is set when the command was stopped because it ran longer than its WithTimeout
+	TimedOut bool
+	// Stderr is the end of what the command wrote to stderr, up to StderrTailSize bytes.  It is empty when stderr was
+	// a terminal
+	Stderr string
+	// Err is the error from os/exec, usually an *exec.ExitError
+	Err error
+}
+
+// Error describes the failure, with secrets redacted
+func (e *ExitError) Error() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "command %s", formatArgs(e.Args))
+	if e.Stages > 1 {
+		fmt.Fprintf(&sb, " (stage %d of %d)", e.Stage+1, e.Stages)
+	}
+	if e.TimedOut {
+		sb.WriteString(" timed out")
+	} else if e.ExitCode >= 0 {
+		fmt.Fprintf(&sb, " failed with exit code %d", e.ExitCode)
+	} else {
+		fmt.Fprintf(&sb, " failed: %v", e.Err)
+	}
+	fmt.Fprintf(&sb, " after %s", e.Duration.Round(time.Millisecond))
+	if stderr := strings.TrimSpace(e.Stderr); stderr != "" {
+		sb.WriteString(": ")
+		sb.WriteString(stderr)
+	}
+	return Redact(sb.String())
+}
+
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+func newExitError(cmd *exec.Cmd, stage int, stages int, duration time.Duration, stderr *tailWriter, err error) *ExitError {
+	ret := &ExitError{
+		Args:     cmd.Args,
+		Stage:    stage,
+		Stages:   stages,
+		ExitCode: -1,
+		Duration: duration,
+		Stderr:   stderr.String(),
+		Err:      err,
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		ret.ExitCode = exitErr.ExitCode()
+	}
+	return ret
+}
+
+// formatArgs joins args into a command line, quoting the ones a shell would split
+func formatArgs(args []string) string {
+	quoted := make([]string, 0, len(args))
+	for _, a := range args {
+		if a == "" || strings.ContainsAny(a, " \t\n'\"\\$`|&;<>()*?") {
+			a = "'" + strings.ReplaceAll(a, "'", `'\''`) + "'"
+		}
+		quoted = append(quoted, a)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// tailWriter keeps the last max bytes written to it
+type tailWriter struct {
+	mu  sync.Mutex
+	max int
+	buf []byte
+	// truncated is set once bytes were dropped
+	truncated bool
+}
+
+func newTailWriter(max int) *tailWriter {
+	return &tailWriter{max: max}
+}
+
+func (t *tailWriter) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.buf = append(t.buf, p...)
+	if over := len(t.buf) - t.max; over > 0 {
+		t.buf = append(t.buf[:0], t.buf[over:]...)
+		t.truncated = true
+	}
+	return len(p), nil
+}
+
+func (t *tailWriter) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.truncated {
+		return "..." + string(t.buf)
+	}
+	return string(t.buf)
+}
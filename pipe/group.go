@@ -0,0 +1,173 @@
+package pipe
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+
+	"github.com/cresta/magehelper/env"
+	"golang.org/x/sync/errgroup"
+)
+
+// groupColors are the ANSI colors of the labels, given to the commands of a Group in turn
+var groupColors = []string{"36", "33", "32", "35", "34", "91", "96", "93", "92", "95"}
+
+// Group runs several commands at once, prefixing each line they print with their label, like docker compose logs
+//
+//	var g pipe.Group
+//	g.Add("go", pipe.NewPiped("golangci-lint", "run"))
+//	g.AddFunc("yaml", func(ctx context.Context, stdout io.Writer, stderr io.Writer) error { ... })
+//	err := g.Run(ctx)
+type Group struct {
+	// Limit is how many commands run at once.  Defaults to the number of CPUs
+	Limit int
+	// FailFast stops the other commands when one fails, instead of running all of them
+	FailFast bool
+	// Stdout and Stderr receive the prefixed output of the commands.  Default to os.Stdout and os.Stderr
+	Stdout io.Writer
+	Stderr io.Writer
+	// NoColor prints the labels without colors.  Colors are also off when ${NO_COLOR} is set
+	NoColor bool
+
+	jobs []groupJob
+}
+
+type groupJob struct {
+	label string
+	run   func(ctx context.Context, stdout io.Writer, stderr io.Writer) error
+}
+
+// Add runs cmd in the group, labelled label
+func (g *Group) Add(label string, cmd *PipedCmd) *Group {
+	return g.AddFunc(label, func(ctx context.Context, stdout io.Writer, stderr io.Writer) error {
+		return cmd.Execute(ctx, nil, stdout, stderr)
+	})
+}
+
+// AddFunc runs fn in the group, labelled label.  fn must write its output to stdout and stderr to have it prefixed
+func (g *Group) AddFunc(label string, fn func(ctx context.Context, stdout io.Writer, stderr io.Writer) error) *Group {
+	g.jobs = append(g.jobs, groupJob{label: label, run: fn})
+	return g
+}
+
+func (g *Group) limit() int {
+	if g.Limit > 0 {
+		return g.Limit
+	}
+	return runtime.NumCPU()
+}
+
+// prefix returns the label of the command at idx, padded to width, colored when w is a terminal
+func (g *Group) prefix(idx int, width int, w io.Writer) string {
+	prefix := fmt.Sprintf("%-*s | ", width, g.jobs[idx].label)
+	if g.NoColor || env.Instance.Get("NO_COLOR") != "" || !isTerminal(w) {
+		return prefix
+	}
+	return "\x1b[" + groupColors[idx%len(groupColors)] + "m" + prefix + "\x1b[0m"
+}
+
+// Run runs the commands of the group and waits for them.  The error joins the errors of every failed command, each
+// prefixed with its label.  With FailFast, it is the error of the first one to fail, and commands that did not start
+// yet are skipped.  When ctx is done, commands that did not start yet are skipped too, and the error includes
+// ctx.Err().
+func (g *Group) Run(ctx context.Context) error {
+	stdout, stderr := g.Stdout, g.Stderr
+	if stdout == nil {
+		stdout = os.Stdout
+	}
+	if stderr == nil {
+		stderr = os.Stderr
+	}
+	width := 0
+	for _, job := range g.jobs {
+		width = max(width, len(job.label))
+	}
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// One lock for both outputs, so lines of different commands never interleave
+	var outputMu sync.Mutex
+	var errsMu sync.Mutex
+	var errs []error
+	// failedFast is set once a command failed with FailFast, and the group stopped the others
+	failedFast := false
+	var eg errgroup.Group
+	eg.SetLimit(g.limit())
+	for idx, job := range g.jobs {
+		job := job
+		jobStdout := &prefixWriter{mu: &outputMu, w: stdout, prefix: g.prefix(idx, width, stdout)}
+		jobStderr := &prefixWriter{mu: &outputMu, w: stderr, prefix: g.prefix(idx, width, stderr)}
+		eg.Go(func() error {
+			if runCtx.Err() != nil {
+				return nil
+			}
+			err := job.run(runCtx, jobStdout, jobStderr)
+			jobStdout.Flush()
+			jobStderr.Flush()
+			if err == nil {
+				return nil
+			}
+			errsMu.Lock()
+			defer errsMu.Unlock()
+			// The other commands fail too once the group stopped them, which is not worth reporting
+			if failedFast {
+				return nil
+			}
+			errs = append(errs, fmt.Errorf("%s: %w", job.label, err))
+			if g.FailFast {
+				failedFast = true
+				cancel()
+			}
+			return nil
+		})
+	}
+	_ = eg.Wait()
+	if ctx.Err() != nil {
+		errs = append(errs, ctx.Err())
+	}
+	return errors.Join(errs...)
+}
+
+// prefixWriter writes every line written to it to w, after prefix.  A line that does not end yet is kept until it
+// does, or until Flush
+type prefixWriter struct {
+	mu      *sync.Mutex
+	w       io.Writer
+	prefix  string
+	partial []byte
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.partial = append(p.partial, b...)
+	end := bytes.LastIndexByte(p.partial, '\n')
+	if end < 0 {
+		return len(b), nil
+	}
+	lines := p.partial[:end+1]
+	var out bytes.Buffer
+	for len(lines) > 0 {
+		idx := bytes.IndexByte(lines, '\n')
+		out.WriteString(p.prefix)
+		out.Write(lines[:idx+1])
+		lines = lines[idx+1:]
+	}
+	p.partial = append(p.partial[:0], p.partial[end+1:]...)
+	if _, err := p.w.Write(out.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Flush writes the line that did not end yet, if any
+func (p *prefixWriter) Flush() {
+	if len(p.partial) == 0 {
+		return
+	}
+	_, _ = p.Write([]byte("\n"))
+}
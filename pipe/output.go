@@ -0,0 +1,129 @@
+package pipe
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/magefile/mage/mg"
+)
+
+// MaxOutputSize is how many bytes Output and the other capture helpers keep before failing, unless the pipeline
+// sets its own limit with WithOutputLimit
+var MaxOutputSize = 64 << 20
+
+// ErrOutputTooLarge is returned by the capture helpers when the output is over the limit
+var ErrOutputTooLarge = errors.New("output too large")
+
+// WithOutputLimit sets how many bytes of output the capture helpers keep before failing with ErrOutputTooLarge
+func (p *PipedCmd) WithOutputLimit(limit int) *PipedCmd {
+	p.outputLimit = limit
+	return p
+}
+
+// Output runs the pipeline and returns what the last command wrote to stdout
+func (p *PipedCmd) Output(ctx context.Context) (string, error) {
+	out, err := p.capture(ctx, false)
+	return string(out), err
+}
+
+// OutputLines runs the pipeline and returns the lines the last command wrote to stdout, without line endings
+func (p *PipedCmd) OutputLines(ctx context.Context) ([]string, error) {
+	out, err := p.Output(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out = strings.TrimSuffix(strings.ReplaceAll(out, "\r\n", "\n"), "\n")
+	if out == "" {
+		return nil, nil
+	}
+	return strings.Split(out, "\n"), nil
+}
+
+// OutputJSON runs the pipeline and decodes what the last command wrote to stdout into v
+func (p *PipedCmd) OutputJSON(ctx context.Context, v any) error {
+	out, err := p.capture(ctx, false)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(out, v); err != nil {
+		return fmt.Errorf("unable to decode output of %s: %w", p.cmd, err)
+	}
+	return nil
+}
+
+// CombinedOutput runs the pipeline and returns what the last command wrote to stdout, and every command wrote to
+// stderr, interleaved
+func (p *PipedCmd) CombinedOutput(ctx context.Context) (string, error) {
+	out, err := p.capture(ctx, true)
+	return string(out), err
+}
+
+// capture runs the pipeline and keeps its stdout, and stderr when combined is set.  With mg.Verbose() the output also
+// goes to the terminal.
+func (p *PipedCmd) capture(ctx context.Context, combined bool) ([]byte, error) {
+	limit := MaxOutputSize
+	for current := p; current != nil; current = current.readFrom {
+		if current.outputLimit > 0 {
+			limit = current.outputLimit
+			break
+		}
+	}
+	out := &limitWriter{limit: limit}
+	var stdout io.Writer = out
+	var stderr io.Writer
+	if combined {
+		stderr = out
+	}
+	if mg.Verbose() {
+		stdout = io.MultiWriter(stdout, os.Stdout)
+		stderr = os.Stderr
+		if combined {
+			stderr = io.MultiWriter(out, os.Stderr)
+		}
+	}
+	err := p.Execute(ctx, nil, stdout, stderr)
+	if out.exceeded() {
+		return nil, fmt.Errorf("%w: %s wrote more than %d bytes", ErrOutputTooLarge, p.cmd, limit)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return out.bytes(), nil
+}
+
+// limitWriter keeps what is written to it, and fails writes over limit bytes
+type limitWriter struct {
+	mu       sync.Mutex
+	limit    int
+	buf      []byte
+	overflow bool
+}
+
+func (l *limitWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.buf)+len(p) > l.limit {
+		l.overflow = true
+		return 0, ErrOutputTooLarge
+	}
+	l.buf = append(l.buf, p...)
+	return len(p), nil
+}
+
+func (l *limitWriter) exceeded() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.overflow
+}
+
+func (l *limitWriter) bytes() []byte {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.buf
+}
@@ -0,0 +1,86 @@
+package pipe
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var pathMu sync.RWMutex
+var extraPaths []string
+
+// AddPath puts dir first on the PATH of every command run by a PipedCmd, and looks commands up there before the
+// PATH of this process.  Tools installed into a project directory take precedence over the ones on the machine.
+func AddPath(dir string) error {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	pathMu.Lock()
+	defer pathMu.Unlock()
+	for _, existing := range extraPaths {
+		if existing == abs {
+			return nil
+		}
+	}
+	extraPaths = append([]string{abs}, extraPaths...)
+	return nil
+}
+
+func addedPaths() []string {
+	pathMu.RLock()
+	defer pathMu.RUnlock()
+	return extraPaths
+}
+
+// LookPath finds the executable name in the directories added with AddPath, then in PATH
+func LookPath(name string) (string, error) {
+	if found := lookAddedPaths(name); found != "" {
+		return found, nil
+	}
+	return exec.LookPath(name)
+}
+
+func lookAddedPaths(name string) string {
+	if strings.ContainsRune(name, filepath.Separator) || strings.ContainsRune(name, '/') {
+		return ""
+	}
+	for _, dir := range addedPaths() {
+		if found, err := exec.LookPath(filepath.Join(dir, name)); err == nil {
+			return found
+		}
+	}
+	return ""
+}
+
+// withPath returns env, or the environment of this process if env is nil, with the added directories in front of
+// PATH
+func withPath(env []string) []string {
+	dirs := addedPaths()
+	if len(dirs) == 0 {
+		return env
+	}
+	if env == nil {
+		env = os.Environ()
+	}
+	ret := make([]string, 0, len(env)+1)
+	prefix := strings.Join(dirs, string(os.PathListSeparator))
+	found := false
+	for _, kv := range env {
+		if k, v, ok := strings.Cut(kv, "="); ok && strings.EqualFold(k, "PATH") && !found {
+			found = true
+			if v != "" {
+				kv = k + "=" + prefix + string(os.PathListSeparator) + v
+			} else {
+				kv = k + "=" + prefix
+			}
+		}
+		ret = append(ret, kv)
+	}
+	if !found {
+		ret = append(ret, "PATH="+prefix)
+	}
+	return ret
+}
@@ -2,23 +2,41 @@ package pipe
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"os/exec"
 	"strings"
+	"time"
 
 	"github.com/cresta/magehelper/env"
-	"github.com/google/shlex"
 	"github.com/magefile/mage/mg"
+	"golang.org/x/term"
 )
 
 type PipedCmd struct {
-	cmd      string
-	args     []string
-	env      []string
-	dir      string
+	cmd    string
+	args   []string
+	env    []string
+	addEnv []string
+	dir    string
+	// pipelineEnv and pipelineDir are defaults for every command of the pipeline
+	pipelineEnv []string
+	pipelineDir string
+	pipefail    *bool
+	outputLimit int
+	retry       *RetryPolicy
+	readOnly    bool
+	timeout     time.Duration
+	stopSignal  os.Signal
+	gracePeriod time.Duration
+	redirects   []redirect
+	// prev is the pipeline that runs before the one this command starts, and prevOp decides if this one runs after
+	// it: &&, || or ;
+	prev     *PipedCmd
+	prevOp   string
 	readFrom *PipedCmd
 	pipeTo   *PipedCmd
 }
@@ -30,89 +48,121 @@ func NewPiped(cmd string, args ...string) *PipedCmd {
 	}
 }
 
-// Shell tries to be like the *sh shell to create a piped command.  It will, after splitting the string, run os.Expand
-// on the parts.  It works correctly for things like this
-//
-//	Shell("echo hi")
-//	Shell("GOOS=linux go build")
-//	Shell("docker run -it ubuntu")
-//	Shell("docker run -v $HOME/.aws:/root/.aws:ro ubuntu")
-//
-// It will not work like bash for things like this
-//
-//	Shell("echo '$HOME'")
-//
-// Since it will first split echo into $HOME, and then escape the HOME
-func Shell(fullLine string) *PipedCmd {
-	ret, err := ShellWithError(fullLine)
-	if err != nil {
-		panic(err)
+// WithEnv replaces the whole environment of this command, including the pipeline defaults of AddPipelineEnv
+func (p *PipedCmd) WithEnv(e []string) *PipedCmd {
+	p.env = e
+	return p
+}
+
+// AddEnv adds KEY=VALUE pairs to the environment of this command, over the environment of env.Instance, or the one
+// of WithEnv
+func (p *PipedCmd) AddEnv(e ...string) *PipedCmd {
+	p.addEnv = append(p.addEnv, e...)
+	return p
+}
+
+// WithDir sets the working directory of this command
+func (p *PipedCmd) WithDir(d string) *PipedCmd {
+	p.dir = d
+	return p
+}
+
+// AddPipelineEnv adds KEY=VALUE pairs to the environment of every command of the pipeline, unless a command
+// replaces its environment with WithEnv.  The AddEnv of a command wins over them
+func (p *PipedCmd) AddPipelineEnv(e ...string) *PipedCmd {
+	p.pipelineEnv = append(p.pipelineEnv, e...)
+	return p
+}
+
+// WithPipelineDir sets the working directory of every command of the pipeline that does not use WithDir
+func (p *PipedCmd) WithPipelineDir(d string) *PipedCmd {
+	p.pipelineDir = d
+	return p
+}
+
+// stages returns the commands of the pipeline, from the first to p
+func (p *PipedCmd) stages() []*PipedCmd {
+	var ret []*PipedCmd
+	for current := p; current != nil; current = current.readFrom {
+		ret = append([]*PipedCmd{current}, ret...)
 	}
 	return ret
 }
 
-func ShellWithError(fullLine string) (*PipedCmd, error) {
-	parts, err := shlex.Split(fullLine)
-	if err != nil {
-		return nil, err
-	}
-	// look for environment assignments at the front
-	envAssignments := make([]string, 0, len(parts))
-	envMap := make(map[string]string)
-	for len(parts) > 0 {
-		first := parts[0]
-		envSplit := strings.SplitN(first, "=", 2)
-		if len(envSplit) != 2 {
-			break
-		}
-		if len(envSplit[0]) == 0 {
-			break
-		}
-		envAssignments = append(envAssignments, first)
-		envMap[envSplit[0]] = envSplit[1]
-		parts = parts[1:]
-	}
-	if len(parts) == 0 {
-		return nil, fmt.Errorf("bad command line %s", fullLine)
-	}
-	prog := parts[0]
-	// Run environment expansion on all the arguments
-	args := parts[1:]
-	for idx := range args {
-		args[idx] = os.Expand(args[idx], func(s string) string {
-			if v, exists := envMap[s]; exists {
-				return v
-			}
-			return env.Instance.Get(s)
-		})
+// resolve returns the commands of the pipeline as they run, with the pipeline defaults applied
+func (p *PipedCmd) resolve() []Command {
+	pipelineEnv, pipelineDir := p.pipelineDefaults()
+	var ret []Command
+	for _, current := range p.stages() {
+		c := Command{
+			Args: append([]string{current.cmd}, current.args...),
+			Dir:  current.dir,
+			Env:  withPath(current.environ(pipelineEnv)),
+		}
+		for _, r := range current.redirects {
+			c.Redirects = append(c.Redirects, r.String())
+		}
+		if c.Dir == "" {
+			c.Dir = pipelineDir
+		}
+		ret = append(ret, c)
 	}
+	return ret
+}
 
-	return &PipedCmd{
-		cmd:  prog,
-		args: args,
-		env:  env.Instance.AddEnv(envAssignments...),
-	}, nil
+// pipelineDefaults returns the pipeline env of every command, from first to last, and the pipeline dir closest to the
+// end of the pipeline
+func (p *PipedCmd) pipelineDefaults() (pipelineEnv []string, pipelineDir string) {
+	for current := p; current != nil; current = current.readFrom {
+		pipelineEnv = append(append([]string(nil), current.pipelineEnv...), pipelineEnv...)
+		if pipelineDir == "" {
+			pipelineDir = current.pipelineDir
+		}
+	}
+	return pipelineEnv, pipelineDir
 }
 
-func (p *PipedCmd) WithEnv(e []string) *PipedCmd {
-	p.env = e
-	return p
+// environ returns the environment of this command, or nil for the environment of this process
+func (p *PipedCmd) environ(pipelineEnv []string) []string {
+	base := p.env
+	if base == nil {
+		if len(pipelineEnv) == 0 && len(p.addEnv) == 0 {
+			return nil
+		}
+		base = env.Instance.AddEnv(pipelineEnv...)
+	}
+	return append(append([]string(nil), base...), p.addEnv...)
 }
 
-func (p *PipedCmd) WithDir(d string) *PipedCmd {
-	p.dir = d
+// WithPipefail sets if the pipeline fails when any command fails, the default like `set -o pipefail`, or only when
+// the last command fails.  The setting of the command closest to the end of the pipeline wins.
+func (p *PipedCmd) WithPipefail(pipefail bool) *PipedCmd {
+	p.pipefail = &pipefail
 	return p
 }
 
+func (p *PipedCmd) pipefailEnabled() bool {
+	for current := p; current != nil; current = current.readFrom {
+		if current.pipefail != nil {
+			return *current.pipefail
+		}
+	}
+	return true
+}
+
+// Shell pipes into the commands of fullLine.  See Shell
 func (p *PipedCmd) Shell(fullLine string) *PipedCmd {
 	next := Shell(fullLine)
-	return p.PipeTo(next)
+	first := next.first()
+	if first.prev != nil {
+		panic("cannot pipe into a list of commands")
+	}
+	p.PipeTo(first)
+	return next
 }
 
+// PipeTo connects the stdout of p to the stdin of into, and returns into so pipelines of any length can be built
 func (p *PipedCmd) PipeTo(into *PipedCmd) *PipedCmd {
-	if p.readFrom != nil {
-		panic("pipe already set to read")
-	}
 	if p.pipeTo != nil {
 		panic("pipe already set to pipe to")
 	}
@@ -124,6 +174,12 @@ func (p *PipedCmd) PipeTo(into *PipedCmd) *PipedCmd {
 	return into
 }
 
+// isTerminal reports if w is a terminal
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	return ok && term.IsTerminal(int(f.Fd()))
+}
+
 func (p *PipedCmd) Pipe(cmd string, args ...string) *PipedCmd {
 	return p.PipeTo(&PipedCmd{
 		cmd:  cmd,
@@ -135,58 +191,155 @@ func (p *PipedCmd) Run(ctx context.Context) error {
 	return p.Execute(ctx, nil, os.Stdout, os.Stderr)
 }
 
+// Execute runs the pipeline, and returns an *ExitError if it failed.  See ExecuteWithStatus
 func (p *PipedCmd) Execute(ctx context.Context, stdin io.Reader, stdout io.Writer, stderr io.Writer) error {
+	_, err := p.ExecuteWithStatus(ctx, stdin, stdout, stderr)
+	return err
+}
+
+// ExecuteWithStatus runs the pipeline and returns how each command finished, in pipeline order.  A failing command
+// does not stop the others.  The error is the *ExitError of the first failing command, or with pipefail disabled of
+// the last command only.  The statuses are nil when a command cannot be started.  With WithRetry, they are of the
+// last attempt.  Lists of pipelines from Shell, like `a && b`, return the result of the last pipeline that ran.
+func (p *PipedCmd) ExecuteWithStatus(ctx context.Context, stdin io.Reader, stdout io.Writer, stderr io.Writer) ([]StageStatus, error) {
+	if first := p.first(); first.prev != nil {
+		statuses, err := first.prev.ExecuteWithStatus(ctx, stdin, stdout, stderr)
+		if (first.prevOp == "&&" && err != nil) || (first.prevOp == "||" && err == nil) {
+			return statuses, err
+		}
+	}
+	// Mask secret environment variables set since the last command, before this one can print them
+	knownSecrets()
+	if r := currentRecorder(); r != nil {
+		pipeline := p.resolve()
+		if handled, err := r.Run(ctx, pipeline, stdin, stdout, stderr); handled {
+			return recordedStatuses(pipeline, err), err
+		}
+	}
+	if DryRun() && !p.isReadOnly() {
+		pipeline := p.resolve()
+		printDryRun(os.Stdout, pipeline)
+		return recordedStatuses(pipeline, nil), nil
+	}
+	if policy := p.retryPolicy(); policy != nil && policy.Attempts > 1 {
+		return p.executeWithRetry(ctx, policy, stdin, stdout, stderr)
+	}
+	return p.executeOnce(ctx, stdin, stdout, stderr)
+}
+
+func (p *PipedCmd) executeOnce(ctx context.Context, stdin io.Reader, stdout io.Writer, stderr io.Writer) ([]StageStatus, error) {
 	cmdCtx, withCancel := context.WithCancel(ctx)
 	defer withCancel()
 	// Setup and start each command
 	commands := make([]*exec.Cmd, 0)
-	for current := p; current != nil; current = current.readFrom {
+	tails := make([]*tailWriter, 0)
+	stageCtxs := make([]context.Context, 0)
+	stopKills := make([]func(), 0)
+	pipeline := p.resolve()
+	for idx, current := range p.stages() {
+		name := current.cmd
+		if found := lookAddedPaths(name); found != "" {
+			name = found
+		}
+		stageCtx := cmdCtx
+		if current.timeout > 0 {
+			var cancel context.CancelFunc
+			stageCtx, cancel = context.WithTimeout(cmdCtx, current.timeout)
+			defer cancel()
+		}
 		//nolint:gosec
-		cmd := exec.CommandContext(cmdCtx, current.cmd, current.args...)
-		cmd.Stderr = stderr
-		cmd.Env = current.env
-		cmd.Dir = p.dir
-		// put the last Pipe() at the first of commands
-		commands = append([]*exec.Cmd{cmd}, commands...)
+		cmd := exec.CommandContext(stageCtx, name, current.args...)
+		stopKill := current.gracefulStop(cmd, current.processGroup(stdin))
+		// Keep the end of stderr for the ExitError, while still streaming it to the caller.  A terminal is passed as
+		// is, since tools only print colors and progress to one, unless a retry policy needs to read stderr
+		tail := newTailWriter(StderrTailSize)
+		switch {
+		case isTerminal(stderr) && p.retryPolicy() == nil:
+			cmd.Stderr = stderr
+		case stderr != nil:
+			cmd.Stderr = io.MultiWriter(stderr, tail)
+		default:
+			cmd.Stderr = tail
+		}
+		cmd.Env = pipeline[idx].Env
+		cmd.Dir = pipeline[idx].Dir
+		commands = append(commands, cmd)
+		tails = append(tails, tail)
+		stageCtxs = append(stageCtxs, stageCtx)
+		stopKills = append(stopKills, stopKill)
+	}
+	// The commands are connected with OS pipes.  Our ends are closed once the commands started, so a command that
+	// stops reading, like head, makes the command writing to it get SIGPIPE instead of blocking forever.
+	var pipeFiles []*os.File
+	closePipes := func() {
+		for _, f := range pipeFiles {
+			_ = f.Close()
+		}
+		pipeFiles = nil
 	}
+	defer closePipes()
 	for idx := range commands {
 		if mg.Verbose() {
-			log.Println("Running command", commands[idx].Path, strings.Join(commands[idx].Args, " "))
+			log.Println("Running command", commands[idx].Path, Redact(strings.Join(commands[idx].Args, " ")))
 		}
 		if idx == 0 {
 			commands[idx].Stdin = stdin
 		} else {
-			p, err := commands[idx-1].StdoutPipe()
+			r, w, err := os.Pipe()
 			if err != nil {
-				return fmt.Errorf("unable to get stdout pipe: %w", err)
+				return nil, fmt.Errorf("unable to create pipe: %w", err)
 			}
-			commands[idx].Stdin = p
+			pipeFiles = append(pipeFiles, r, w)
+			commands[idx-1].Stdout = w
+			commands[idx].Stdin = r
 		}
 		if idx == len(commands)-1 {
 			commands[idx].Stdout = stdout
 		}
 	}
+	for idx, current := range p.stages() {
+		opened, err := applyRedirects(commands[idx], current.redirects)
+		if err != nil {
+			return nil, err
+		}
+		pipeFiles = append(pipeFiles, opened...)
+	}
+	started := make([]time.Time, len(commands))
 	for idx, cmd := range commands {
+		started[idx] = time.Now()
 		if err := cmd.Start(); err != nil {
 			withCancel()
+			closePipes()
 			// Wait for the previous commands to finish so we do not leak
 			for i := 0; i < idx; i++ {
 				_ = commands[i].Wait()
+				stopKills[i]()
 			}
-			return fmt.Errorf("unable to start command: %w", err)
+			return nil, fmt.Errorf("unable to start command %s: %w", Redact(formatArgs(cmd.Args)), err)
 		}
 	}
-	var waitErr error
+	closePipes()
+	statuses := make([]StageStatus, len(commands))
 	for i := len(commands) - 1; i >= 0; i-- {
-		// https://golang.org/pkg/os/exec/#Cmd.StdoutPipe
-		// "It is thus incorrect to call Wait before all reads from the pipe have completed"
-		// So we need to Wait for the last in the chain first
+		// Wait for the last in the chain first, since it is the one that finishes reading the output
 		cmd := commands[i]
-		if err := cmd.Wait(); err != nil {
-			// We will end up returning the *last* wait error, which will be the first command of the pipes that failed
-			waitErr = err
-			withCancel()
+		err := cmd.Wait()
+		stopKills[i]()
+		statuses[i] = StageStatus{Args: cmd.Args, Duration: time.Since(started[i])}
+		// exec.ErrWaitDelay is an error too: the command passed, but its output was cut off while something it started
+		// kept writing
+		if err == nil {
+			continue
+		}
+		exitErr := newExitError(cmd, i, len(commands), statuses[i].Duration, tails[i], err)
+		exitErr.TimedOut = errors.Is(stageCtxs[i].Err(), context.DeadlineExceeded) && ctx.Err() == nil
+		statuses[i].ExitCode = exitErr.ExitCode
+		// A command before the last one is killed by SIGPIPE when a later one exits without reading everything
+		if i < len(commands)-1 && brokenPipe(err) {
+			statuses[i].BrokenPipe = true
+			continue
 		}
+		statuses[i].Err = exitErr
 	}
-	return waitErr
+	return statuses, pipelineError(statuses, p.pipefailEnabled())
 }
@@ -0,0 +1,409 @@
+package pipe
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecuteExitError(t *testing.T) {
+	var stderr strings.Builder
+	err := NewPiped("sh", "-c", "echo oops >&2; exit 3").
+		Pipe("cat").
+		Execute(context.Background(), nil, nil, &stderr)
+	var exitErr *ExitError
+	require.True(t, errors.As(err, &exitErr))
+	require.Equal(t, []string{"sh", "-c", "echo oops >&2; exit 3"}, exitErr.Args)
+	require.Equal(t, 0, exitErr.Stage)
+	require.Equal(t, 2, exitErr.Stages)
+	require.Equal(t, 3, exitErr.ExitCode)
+	require.Equal(t, "oops\n", exitErr.Stderr)
+	require.Equal(t, "oops\n", stderr.String())
+	require.Contains(t, err.Error(), "command sh -c 'echo oops >&2; exit 3' (stage 1 of 2) failed with exit code 3")
+}
+
+func TestTailWriter(t *testing.T) {
+	w := newTailWriter(4)
+	_, _ = w.Write([]byte("ab"))
+	require.Equal(t, "ab", w.String())
+	_, _ = w.Write([]byte("cdef"))
+	require.Equal(t, "...cdef", w.String())
+}
+
+func TestExecuteWithStatusBrokenPipe(t *testing.T) {
+	var stdout strings.Builder
+	statuses, err := NewPiped("yes").Pipe("head", "-n", "2").ExecuteWithStatus(context.Background(), nil, &stdout, nil)
+	require.NoError(t, err)
+	require.Equal(t, "y\ny\n", stdout.String())
+	require.Len(t, statuses, 2)
+	require.True(t, statuses[0].BrokenPipe)
+	require.NoError(t, statuses[0].Err)
+	require.Equal(t, 0, statuses[1].ExitCode)
+}
+
+func TestExecuteWithStatusPipefail(t *testing.T) {
+	ctx := context.Background()
+	statuses, err := NewPiped("sh", "-c", "echo hi; exit 2").Pipe("cat").ExecuteWithStatus(ctx, nil, nil, nil)
+	require.Error(t, err)
+	require.Equal(t, 2, statuses[0].ExitCode)
+	require.Equal(t, statuses[0].Err, err)
+	require.Equal(t, 0, statuses[1].ExitCode)
+
+	statuses, err = NewPiped("sh", "-c", "echo hi; exit 2").Pipe("cat").WithPipefail(false).ExecuteWithStatus(ctx, nil, nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, 2, statuses[0].ExitCode)
+	require.Error(t, statuses[0].Err)
+}
+
+func TestExecuteStageDirs(t *testing.T) {
+	first, second := t.TempDir(), t.TempDir()
+	var stdout strings.Builder
+	err := NewPiped("pwd").WithDir(first).
+		Pipe("sh", "-c", "cat; pwd").WithDir(second).
+		Execute(context.Background(), nil, &stdout, nil)
+	require.NoError(t, err)
+	require.Equal(t, first+"\n"+second+"\n", stdout.String())
+
+	stdout.Reset()
+	err = NewPiped("pwd").
+		Pipe("sh", "-c", "cat; pwd").WithDir(second).
+		WithPipelineDir(first).
+		Execute(context.Background(), nil, &stdout, nil)
+	require.NoError(t, err)
+	require.Equal(t, first+"\n"+second+"\n", stdout.String())
+}
+
+func TestExecuteStageEnv(t *testing.T) {
+	t.Setenv("PIPE_TEST_BASE", "base")
+	var stdout strings.Builder
+	err := NewPiped("sh", "-c", `echo "$PIPE_TEST_BASE $PIPE_TEST_A $PIPE_TEST_B"`).AddEnv("PIPE_TEST_A=a").
+		Pipe("sh", "-c", `cat; echo "$PIPE_TEST_BASE $PIPE_TEST_A $PIPE_TEST_B"`).AddEnv("PIPE_TEST_B=b").
+		AddPipelineEnv("PIPE_TEST_A=pipeline", "PIPE_TEST_B=pipeline").
+		Execute(context.Background(), nil, &stdout, nil)
+	require.NoError(t, err)
+	require.Equal(t, "base a pipeline\nbase pipeline b\n", stdout.String())
+
+	stdout.Reset()
+	err = NewPiped("sh", "-c", `echo "$PIPE_TEST_BASE $PIPE_TEST_A"`).WithEnv([]string{"PIPE_TEST_A=replaced"}).
+		AddPipelineEnv("PIPE_TEST_A=pipeline").
+		Execute(context.Background(), nil, &stdout, nil)
+	require.NoError(t, err)
+	require.Equal(t, " replaced\n", stdout.String())
+}
+
+func TestShellEnv(t *testing.T) {
+	var stdout strings.Builder
+	err := Shell(`PIPE_TEST_A=a sh -c "echo $PIPE_TEST_A"`).
+		Shell(`PIPE_TEST_A=b sh -c "cat; echo \$PIPE_TEST_A"`).
+		Execute(context.Background(), nil, &stdout, nil)
+	require.NoError(t, err)
+	require.Equal(t, "a\nb\n", stdout.String())
+}
+
+func TestOutputHelpers(t *testing.T) {
+	ctx := context.Background()
+	out, err := NewPiped("printf", "a\nb\n").Output(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "a\nb\n", out)
+
+	lines, err := NewPiped("printf", "a\nb\n").Pipe("sort", "-r").OutputLines(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"b", "a"}, lines)
+
+	lines, err = NewPiped("true").OutputLines(ctx)
+	require.NoError(t, err)
+	require.Empty(t, lines)
+
+	var v struct {
+		Name string `json:"name"`
+	}
+	require.NoError(t, NewPiped("echo", `{"name": "x"}`).OutputJSON(ctx, &v))
+	require.Equal(t, "x", v.Name)
+
+	out, err = NewPiped("sh", "-c", "echo out; echo err >&2").CombinedOutput(ctx)
+	require.NoError(t, err)
+	require.Contains(t, out, "out\n")
+	require.Contains(t, out, "err\n")
+
+	_, err = NewPiped("yes").WithOutputLimit(1024).Output(ctx)
+	require.ErrorIs(t, err, ErrOutputTooLarge)
+}
+
+func TestWithRetry(t *testing.T) {
+	ctx := context.Background()
+	count := filepath.Join(t.TempDir(), "count")
+	// Fails with a transient error twice, reading stdin every time, then passes
+	script := `read -r line; echo "$line" >> ` + count + `; echo "attempt $(wc -l < ` + count + `)"; [ "$(wc -l < ` + count + `)" -ge 3 ] || { echo "503 Service Unavailable" >&2; exit 1; }`
+	policy := RetryPolicy{Attempts: 3, InitialBackoff: time.Millisecond, Retryable: RetryOnStderr("503")}
+	var stdout strings.Builder
+	require.NoError(t, NewPiped("sh", "-c", script).WithRetry(policy).Execute(ctx, strings.NewReader("pw\n"), &stdout, nil))
+	content, err := os.ReadFile(count)
+	require.NoError(t, err)
+	require.Equal(t, "pw\npw\npw\n", string(content))
+	// Only the output of the attempt that passed is kept
+	require.Equal(t, "attempt3\n", strings.ReplaceAll(stdout.String(), " ", ""))
+
+	// Failures the policy does not retry fail at once
+	require.NoError(t, os.Remove(count))
+	policy.Retryable = RetryOnExitCodes(2)
+	err = NewPiped("sh", "-c", script).WithRetry(policy).Execute(ctx, strings.NewReader("pw\n"), nil, nil)
+	var exitErr *ExitError
+	require.True(t, errors.As(err, &exitErr))
+	require.Equal(t, 1, exitErr.ExitCode)
+	content, err = os.ReadFile(count)
+	require.NoError(t, err)
+	require.Equal(t, "pw\n", string(content))
+}
+
+func TestRetryBackoff(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 4 * time.Second}
+	for retry, maxWait := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second} {
+		wait := policy.backoff(retry + 1)
+		require.LessOrEqual(t, wait, maxWait)
+		require.GreaterOrEqual(t, wait, maxWait/2)
+	}
+}
+
+func TestWithTimeoutStopsGracefully(t *testing.T) {
+	var stdout strings.Builder
+	// The shell cleans up on SIGTERM, and the sleep it started is stopped with it
+	script := `trap 'echo cleanup; exit 7' TERM; sleep 10 & wait`
+	start := time.Now()
+	err := NewPiped("sh", "-c", script).WithTimeout(100*time.Millisecond).Execute(context.Background(), nil, &stdout, nil)
+	var exitErr *ExitError
+	require.True(t, errors.As(err, &exitErr))
+	require.True(t, exitErr.TimedOut)
+	require.Equal(t, 7, exitErr.ExitCode)
+	require.Equal(t, "cleanup\n", stdout.String())
+	require.Less(t, time.Since(start), 5*time.Second)
+	require.Contains(t, err.Error(), "timed out")
+}
+
+func TestIsTerminal(t *testing.T) {
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+	defer r.Close()
+	defer w.Close()
+	// Only a terminal is passed to commands as is, everything else keeps the stderr tail
+	require.False(t, isTerminal(w))
+	require.False(t, isTerminal(&strings.Builder{}))
+	require.False(t, isTerminal(nil))
+}
+
+func TestProcessGroup(t *testing.T) {
+	defer func(restore func() bool) { hasTerminal = restore }(hasTerminal)
+	// In a terminal, commands that did not ask for a graceful stop stay in the foreground, where they can prompt on
+	// /dev/tty
+	hasTerminal = func() bool { return true }
+	require.False(t, NewPiped("ssh", "host").processGroup(nil))
+	require.True(t, NewPiped("ssh", "host").WithTimeout(time.Second).processGroup(nil))
+	require.True(t, NewPiped("ssh", "host").WithStopSignal(os.Interrupt).processGroup(nil))
+	require.False(t, NewPiped("ssh", "host").WithTimeout(time.Second).processGroup(os.Stdin))
+	hasTerminal = func() bool { return false }
+	require.True(t, NewPiped("ssh", "host").processGroup(nil))
+}
+
+func TestBackgroundOutput(t *testing.T) {
+	var stdout strings.Builder
+	// Output of background processes is waited for, unless the command asked for a graceful stop
+	script := `(sleep 2; echo late) & echo early`
+	require.NoError(t, NewPiped("sh", "-c", script).Execute(context.Background(), nil, &stdout, nil))
+	require.Equal(t, "early\nlate\n", stdout.String())
+
+	stdout.Reset()
+	err := NewPiped("sh", "-c", script).WithGracePeriod(100*time.Millisecond).Execute(context.Background(), nil, &stdout, nil)
+	require.ErrorIs(t, err, exec.ErrWaitDelay)
+	require.Equal(t, "early\n", stdout.String())
+}
+
+func TestGracePeriodKills(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := NewPiped("sh", "-c", `trap '' TERM; sleep 10`).WithGracePeriod(200*time.Millisecond).Execute(ctx, nil, nil, nil)
+	var exitErr *ExitError
+	require.True(t, errors.As(err, &exitErr))
+	require.False(t, exitErr.TimedOut)
+	require.Equal(t, -1, exitErr.ExitCode)
+	require.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestDryRun(t *testing.T) {
+	t.Setenv("MAGEHELPER_DRY_RUN", "true")
+	marker := filepath.Join(t.TempDir(), "marker")
+	require.NoError(t, NewPiped("touch", marker).Run(context.Background()))
+	require.NoFileExists(t, marker)
+
+	out, err := NewPiped("echo", "read only").ReadOnly().Output(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "read only\n", out)
+
+	var buf strings.Builder
+	t.Setenv("PIPE_TEST_REMOVED", "x")
+	printDryRun(&buf, NewPiped("echo", "a b").AddEnv("PIPE_TEST_A=a").Pipe("tr", "a", "b").WithDir("/tmp").resolve())
+	require.Equal(t, "Dry run: echo 'a b' | tr a b\n  echo\n    env: PIPE_TEST_A=a\n  tr\n    dir: /tmp\n", buf.String())
+
+	buf.Reset()
+	printDryRun(&buf, NewPiped("env").WithEnv([]string{"PIPE_TEST_A=a"}).resolve())
+	require.Contains(t, buf.String(), "  env: -PIPE_TEST_REMOVED\n")
+}
+
+func TestRecording(t *testing.T) {
+	rec := &Recording{
+		Stub: func(pipeline []Command, _ io.Reader, stdout io.Writer) error {
+			_, err := io.WriteString(stdout, pipeline[0].Args[0]+"\n")
+			return err
+		},
+	}
+	defer SetRecorder(rec)()
+	out, err := NewPiped("git", "rev-parse", "HEAD").Pipe("head", "-c", "7").Output(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "git\n", out)
+	require.Equal(t, []string{"git rev-parse HEAD | head -c 7"}, rec.Commands())
+}
+
+func TestRedact(t *testing.T) {
+	t.Setenv("PIPE_TEST_TOKEN", "env-secret-value")
+	t.Setenv("PIPE_TEST_UNSET_TOKEN", "none")
+	var masked []string
+	t.Cleanup(OnSecret(func(value string) {
+		masked = append(masked, value)
+	}))
+	require.Contains(t, masked, "env-secret-value")
+	// Placeholders are not secrets
+	require.NotContains(t, masked, "none")
+	AddSecret("registered-secret", "abc")
+	require.Contains(t, masked, "registered-secret")
+	require.NotContains(t, masked, "abc")
+	require.Equal(t, "login *** *** abc", Redact("login env-secret-value registered-secret abc"))
+
+	// Secret environment variables set after the hook was added reach it too, once
+	t.Setenv("PIPE_TEST_LATER_TOKEN", "later-secret-value")
+	require.Equal(t, "***", Redact("later-secret-value"))
+	require.Equal(t, "***", Redact("later-secret-value"))
+	count := 0
+	for _, v := range masked {
+		if v == "later-secret-value" {
+			count++
+		}
+	}
+	require.Equal(t, 1, count)
+
+	err := NewPiped("sh", "-c", "echo registered-secret >&2; exit 1", "env-secret-value").Execute(context.Background(), nil, nil, nil)
+	require.Error(t, err)
+	require.NotContains(t, err.Error(), "secret")
+
+	var buf strings.Builder
+	printDryRun(&buf, NewPiped("docker", "login").AddEnv("OTHER_PASSWORD=hunter22").resolve())
+	require.Equal(t, "Dry run: docker login\n  env: OTHER_PASSWORD=***\n", buf.String())
+}
+
+func TestShellGrammar(t *testing.T) {
+	t.Setenv("PIPE_TEST_WORD", "a b")
+	dir := t.TempDir()
+	ctx := context.Background()
+	var stdout strings.Builder
+	run := func(line string) error {
+		stdout.Reset()
+		cmd, err := ShellWithError(line)
+		require.NoError(t, err)
+		return cmd.Execute(ctx, nil, &stdout, nil)
+	}
+	require.NoError(t, run(`printf '%s|' '$PIPE_TEST_WORD' "$PIPE_TEST_WORD" $PIPE_TEST_WORD`))
+	require.Equal(t, "$PIPE_TEST_WORD|a b|a|b|", stdout.String())
+
+	require.NoError(t, run(`printf 'b\na\n' | sort | tr a-z A-Z`))
+	require.Equal(t, "A\nB\n", stdout.String())
+
+	out := filepath.Join(dir, "out.txt")
+	require.NoError(t, run(`sh -c 'echo out; echo err >&2' > `+out+` 2>&1`))
+	require.Empty(t, stdout.String())
+	content, err := os.ReadFile(out)
+	require.NoError(t, err)
+	require.Equal(t, "out\nerr\n", string(content))
+
+	require.NoError(t, run(`echo more >> `+out+` && tr a-z A-Z < `+out))
+	require.Equal(t, "OUT\nERR\nMORE\n", stdout.String())
+
+	require.NoError(t, run(`false && echo skipped || echo recovered; echo always`))
+	require.Equal(t, "recovered\nalways\n", stdout.String())
+
+	require.NoError(t, run(`echo first; echo b && echo c`))
+	require.Equal(t, "first\nb\nc\n", stdout.String())
+
+	require.NoError(t, run(`echo first; false && echo skipped; echo last`))
+	require.Equal(t, "first\nlast\n", stdout.String())
+
+	err = run(`true && sh -c 'exit 4'`)
+	var exitErr *ExitError
+	require.True(t, errors.As(err, &exitErr))
+	require.Equal(t, 4, exitErr.ExitCode)
+
+	for _, bad := range []string{"", "echo $(date)", "if true; then echo; fi", "(echo a)", "echo a &"} {
+		_, err := ShellWithError(bad)
+		require.Error(t, err, bad)
+	}
+}
+
+func TestShellRecorded(t *testing.T) {
+	rec := &Recording{}
+	defer SetRecorder(rec)()
+	require.NoError(t, Shell(`go test ./... 2>&1 | tee "test output.txt" && echo done`).Run(context.Background()))
+	require.Equal(t, []string{"go test ./... 2>&1 | tee 'test output.txt'", "echo done"}, rec.Commands())
+}
+
+func TestGroup(t *testing.T) {
+	var stdout, stderr strings.Builder
+	g := Group{Limit: 2, Stdout: &stdout, Stderr: &stderr, NoColor: true}
+	g.Add("a", NewPiped("sh", "-c", "echo one; echo two"))
+	g.Add("long", NewPiped("sh", "-c", "printf partial; echo oops >&2; exit 2"))
+	g.AddFunc("fn", func(ctx context.Context, stdout io.Writer, stderr io.Writer) error {
+		return errors.New("broken")
+	})
+	err := g.Run(context.Background())
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "long: command")
+	require.Contains(t, err.Error(), "fn: broken")
+	var exitErr *ExitError
+	require.True(t, errors.As(err, &exitErr))
+	require.Equal(t, 2, exitErr.ExitCode)
+	require.Contains(t, stdout.String(), "a    | one\na    | two\n")
+	require.Contains(t, stdout.String(), "long | partial\n")
+	require.Equal(t, "long | oops\n", stderr.String())
+}
+
+func TestGroupFailFast(t *testing.T) {
+	g := Group{Limit: 1, FailFast: true, Stdout: io.Discard, Stderr: io.Discard}
+	ran := false
+	g.Add("fail", NewPiped("false"))
+	g.AddFunc("skipped", func(ctx context.Context, stdout io.Writer, stderr io.Writer) error {
+		ran = true
+		return nil
+	})
+	err := g.Run(context.Background())
+	require.Error(t, err)
+	require.True(t, strings.HasPrefix(err.Error(), "fail: "))
+	require.False(t, ran)
+}
+
+func TestGroupCanceled(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	var stdout strings.Builder
+	// Labels are only colored on a terminal
+	g := Group{Limit: 1, FailFast: true, Stdout: &stdout, Stderr: io.Discard}
+	g.Add("slow", NewPiped("sh", "-c", "echo started; exec sleep 5"))
+	g.Add("skipped", NewPiped("echo", "skipped"))
+	err := g.Run(ctx)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Contains(t, err.Error(), "slow: ")
+	require.Equal(t, "slow    | started\n", stdout.String())
+}
@@ -0,0 +1,18 @@
+//go:build !unix
+
+package pipe
+
+import (
+	"os"
+	"os/exec"
+)
+
+// defaultStopSignal is os.Kill, since other platforms cannot send interrupts to a process
+var defaultStopSignal = os.Kill
+
+// setProcessGroup does nothing, since process groups only exist on unix
+func setProcessGroup(_ *exec.Cmd) {}
+
+func signalProcess(cmd *exec.Cmd, sig os.Signal, _ bool) error {
+	return cmd.Process.Signal(sig)
+}
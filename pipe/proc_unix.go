@@ -0,0 +1,29 @@
+//go:build unix
+
+package pipe
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+var defaultStopSignal os.Signal = syscall.SIGTERM
+
+// setProcessGroup makes cmd the leader of a new process group, so signals reach every process it starts
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// signalProcess sends sig to cmd, or to the process group it leads when group is set
+func signalProcess(cmd *exec.Cmd, sig os.Signal, group bool) error {
+	s, ok := sig.(syscall.Signal)
+	if !group || !ok {
+		return cmd.Process.Signal(sig)
+	}
+	if err := syscall.Kill(-cmd.Process.Pid, s); err != nil && !errors.Is(err, syscall.ESRCH) {
+		return err
+	}
+	return nil
+}
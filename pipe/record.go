@@ -0,0 +1,199 @@
+package pipe
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/cresta/magehelper/env"
+)
+
+// Command is one command of a pipeline, as it runs
+type Command struct {
+	// Args is the command line, starting with the program
+	Args []string
+	// Dir is the working directory, or "" for the current one
+	Dir string
+	// Env is the whole environment, or nil for the environment of this process
+	Env []string
+	// Redirects are the redirections of Shell, like 2>&1, in order
+	Redirects []string
+}
+
+// String returns the command line, with secrets redacted
+func (c Command) String() string {
+	return Redact(strings.Join(append([]string{formatArgs(c.Args)}, c.Redirects...), " "))
+}
+
+// EnvDiff returns how Env differs from the environment of this process: KEY=VALUE for every added or changed
+// variable, and -KEY for every removed one, sorted
+func (c Command) EnvDiff() []string {
+	if c.Env == nil {
+		return nil
+	}
+	parent := envMap(os.Environ())
+	current := envMap(c.Env)
+	var ret []string
+	for k, v := range current {
+		if old, exists := parent[k]; !exists || old != v {
+			ret = append(ret, k+"="+v)
+		}
+	}
+	for k := range parent {
+		if _, exists := current[k]; !exists {
+			ret = append(ret, "-"+k)
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// envMap turns KEY=VALUE pairs into a map.  Later pairs win, like they do for exec.Cmd
+func envMap(e []string) map[string]string {
+	ret := make(map[string]string, len(e))
+	for _, kv := range e {
+		k, v, _ := strings.Cut(kv, "=")
+		ret[k] = v
+	}
+	return ret
+}
+
+// Recorder sees every pipeline before it runs, and can run it in its place.  Install one with SetRecorder
+type Recorder interface {
+	// Run is called with the commands of a pipeline, in order.  When handled is false the pipeline runs as usual,
+	// otherwise err is its result
+	Run(ctx context.Context, pipeline []Command, stdin io.Reader, stdout io.Writer, stderr io.Writer) (handled bool, err error)
+}
+
+var recorderMu sync.RWMutex
+var recorder Recorder
+
+// SetRecorder installs r for every pipeline, and returns a function that installs the previous one again.  Tests use
+// it to check and stub the commands code runs
+func SetRecorder(r Recorder) (restore func()) {
+	recorderMu.Lock()
+	defer recorderMu.Unlock()
+	previous := recorder
+	recorder = r
+	return func() {
+		recorderMu.Lock()
+		defer recorderMu.Unlock()
+		recorder = previous
+	}
+}
+
+func currentRecorder() Recorder {
+	recorderMu.RLock()
+	defer recorderMu.RUnlock()
+	return recorder
+}
+
+// Recording is a Recorder that records pipelines instead of running them
+type Recording struct {
+	// Stub, when set, runs in place of every pipeline.  It can write output and return an error
+	Stub func(pipeline []Command, stdin io.Reader, stdout io.Writer) error
+
+	mu        sync.Mutex
+	pipelines [][]Command
+}
+
+var _ Recorder = &Recording{}
+
+func (r *Recording) Run(_ context.Context, pipeline []Command, stdin io.Reader, stdout io.Writer, _ io.Writer) (bool, error) {
+	r.mu.Lock()
+	r.pipelines = append(r.pipelines, pipeline)
+	r.mu.Unlock()
+	if r.Stub == nil {
+		return true, nil
+	}
+	if stdout == nil {
+		stdout = io.Discard
+	}
+	return true, r.Stub(pipeline, stdin, stdout)
+}
+
+// Pipelines returns the recorded pipelines, in the order they ran
+func (r *Recording) Pipelines() [][]Command {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([][]Command(nil), r.pipelines...)
+}
+
+// Commands returns the recorded pipelines as command lines, like "aws ecr get-login-password | docker login"
+func (r *Recording) Commands() []string {
+	var ret []string
+	for _, pipeline := range r.Pipelines() {
+		ret = append(ret, formatPipeline(pipeline))
+	}
+	return ret
+}
+
+func formatPipeline(pipeline []Command) string {
+	parts := make([]string, 0, len(pipeline))
+	for _, c := range pipeline {
+		parts = append(parts, c.String())
+	}
+	return strings.Join(parts, " | ")
+}
+
+// recordedStatuses returns passing statuses for a pipeline that did not run, with err as the result of the last
+// command
+func recordedStatuses(pipeline []Command, err error) []StageStatus {
+	ret := make([]StageStatus, len(pipeline))
+	for i, c := range pipeline {
+		ret[i].Args = c.Args
+	}
+	if err != nil && len(ret) > 0 {
+		ret[len(ret)-1].Err = err
+		ret[len(ret)-1].ExitCode = -1
+	}
+	return ret
+}
+
+// DryRun reports if ${MAGEHELPER_DRY_RUN} is set.  Pipelines then print what they would run instead of running,
+// unless they are ReadOnly
+func DryRun() bool {
+	dryRun, err := strconv.ParseBool(env.Instance.Get("MAGEHELPER_DRY_RUN"))
+	return err == nil && dryRun
+}
+
+// ReadOnly marks the pipeline as only reading, like `git rev-parse`, so it runs even in dry run mode
+func (p *PipedCmd) ReadOnly() *PipedCmd {
+	p.readOnly = true
+	return p
+}
+
+func (p *PipedCmd) isReadOnly() bool {
+	for current := p; current != nil; current = current.readFrom {
+		if current.readOnly {
+			return true
+		}
+	}
+	return false
+}
+
+func printDryRun(w io.Writer, pipeline []Command) {
+	fmt.Fprintf(w, "Dry run: %s\n", formatPipeline(pipeline))
+	// The dir and env of each command of a longer pipeline are under its name
+	indent := "  "
+	if len(pipeline) > 1 {
+		indent = "    "
+	}
+	for _, c := range pipeline {
+		diff := c.EnvDiff()
+		if len(pipeline) > 1 && (c.Dir != "" || len(diff) > 0) {
+			fmt.Fprintf(w, "  %s\n", c.Args[0])
+		}
+		if c.Dir != "" {
+			fmt.Fprintf(w, "%sdir: %s\n", indent, c.Dir)
+		}
+		for _, e := range diff {
+			fmt.Fprintf(w, "%senv: %s\n", indent, redactEnv(e))
+		}
+	}
+}
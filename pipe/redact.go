@@ -0,0 +1,133 @@
+package pipe
+
+import (
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/cresta/magehelper/env"
+)
+
+// SecretEnvPatterns match, with path.Match, the names of environment variables that hold secrets.  Their values are
+// redacted from everything pipe prints
+var SecretEnvPatterns = []string{"*_PASSWORD", "*_TOKEN", "*_SECRET", "*_SECRET_ACCESS_KEY", "*_API_KEY", "GHCR_PAT"}
+
+const redacted = "***"
+
+// minSecretLength is the shortest value that is redacted, so a secret like "1" does not mangle all the output
+const minSecretLength = 4
+
+// placeholderValues are values of secret environment variables that are not secrets, like TOKEN=none.  Redacting and
+// masking them would hide common words everywhere
+var placeholderValues = map[string]bool{
+	"true": true, "false": true, "yes": true, "no": true, "none": true, "null": true, "nil": true, "empty": true,
+	"unset": true, "undefined": true, "changeme": true, "dummy": true,
+}
+
+var secretsMu sync.RWMutex
+var secrets = make(map[string]bool)
+var secretHooks []*func(value string)
+
+// isSecretValue reports if v is worth redacting
+func isSecretValue(v string) bool {
+	return len(v) >= minSecretLength && !placeholderValues[strings.ToLower(v)]
+}
+
+// IsSecretEnv reports if the environment variable name holds a secret, by SecretEnvPatterns
+func IsSecretEnv(name string) bool {
+	for _, pattern := range SecretEnvPatterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// AddSecret registers values to redact, and passes them to the OnSecret hooks
+func AddSecret(values ...string) {
+	var added []string
+	secretsMu.Lock()
+	for _, v := range values {
+		if isSecretValue(v) && !secrets[v] {
+			secrets[v] = true
+			added = append(added, v)
+		}
+	}
+	hooks := secretHooks
+	secretsMu.Unlock()
+	for _, v := range added {
+		for _, hook := range hooks {
+			(*hook)(v)
+		}
+	}
+}
+
+// OnSecret calls fn with every secret known now, and every one added later, until remove is called.  CI backends use
+// it to mask secrets in their logs
+func OnSecret(fn func(value string)) (remove func()) {
+	hook := &fn
+	secretsMu.Lock()
+	secretHooks = append(slices.Clone(secretHooks), hook)
+	known := make([]string, 0, len(secrets))
+	for v := range secrets {
+		known = append(known, v)
+	}
+	secretsMu.Unlock()
+	for _, v := range known {
+		fn(v)
+	}
+	// Secret environment variables reach the hooks once they are first seen
+	knownSecrets()
+	return func() {
+		secretsMu.Lock()
+		defer secretsMu.Unlock()
+		for idx, h := range secretHooks {
+			if h == hook {
+				secretHooks = slices.Delete(slices.Clone(secretHooks), idx, idx+1)
+				return
+			}
+		}
+	}
+}
+
+// knownSecrets returns the registered secrets and the values of secret environment variables, longest first.  Values
+// of secret environment variables that were not seen before are registered with AddSecret, so the OnSecret hooks see
+// them even when they were set after the hooks were added
+func knownSecrets() []string {
+	var fromEnv []string
+	for name, v := range envMap(env.Instance.AddEnv()) {
+		if IsSecretEnv(name) {
+			fromEnv = append(fromEnv, v)
+		}
+	}
+	AddSecret(fromEnv...)
+	secretsMu.RLock()
+	ret := make([]string, 0, len(secrets))
+	for v := range secrets {
+		ret = append(ret, v)
+	}
+	secretsMu.RUnlock()
+	// Longer secrets first, so a secret containing another is replaced whole
+	sort.Slice(ret, func(i, j int) bool {
+		return len(ret[i]) > len(ret[j])
+	})
+	return ret
+}
+
+// Redact replaces every known secret in s with ***
+func Redact(s string) string {
+	for _, v := range knownSecrets() {
+		s = strings.ReplaceAll(s, v, redacted)
+	}
+	return s
+}
+
+// redactEnv redacts a KEY=VALUE pair, hiding the whole value when KEY is a secret
+func redactEnv(kv string) string {
+	if name, _, found := strings.Cut(kv, "="); found && IsSecretEnv(name) {
+		return name + "=" + redacted
+	}
+	return Redact(kv)
+}
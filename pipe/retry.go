@@ -0,0 +1,158 @@
+package pipe
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// RetryPolicy reruns a pipeline that failed in a way that may pass on another attempt
+type RetryPolicy struct {
+	// Attempts is how many times the pipeline runs at most, including the first.  Less than 2 never retries
+	Attempts int
+	// InitialBackoff is the wait before the first retry.  It doubles on every retry, up to MaxBackoff, and up to half
+	// of it is taken off at random so parallel builds do not retry in lockstep.  Defaults to 1 second
+	InitialBackoff time.Duration
+	// MaxBackoff defaults to 30 seconds
+	MaxBackoff time.Duration
+	// Retryable reports if a failure is worth retrying.  Defaults to retrying every failure
+	Retryable func(err *ExitError) bool
+}
+
+// TransientErrors match the stderr of network failures that usually pass on another attempt
+var TransientErrors = []string{
+	"TLS handshake timeout",
+	"i/o timeout",
+	"connection reset by peer",
+	"connection refused",
+	"unexpected EOF",
+	"no such host",
+	"Client.Timeout exceeded",
+	"500 Internal Server Error",
+	"502 Bad Gateway",
+	"503 Service Unavailable",
+	"504 Gateway Timeout",
+	"429 Too Many Requests",
+	"toomanyrequests",
+}
+
+// NetworkRetry retries commands that talk to remote services, like registries, when they fail with one of
+// TransientErrors
+var NetworkRetry = RetryPolicy{
+	Attempts:  4,
+	Retryable: RetryOnStderr(TransientErrors...),
+}
+
+// RetryOnExitCodes retries failures with one of the exit codes
+func RetryOnExitCodes(codes ...int) func(err *ExitError) bool {
+	return func(err *ExitError) bool {
+		for _, code := range codes {
+			if err.ExitCode == code {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// RetryOnStderr retries failures whose stderr matches one of the regular expressions
+func RetryOnStderr(patterns ...string) func(err *ExitError) bool {
+	rgx := regexp.MustCompile(strings.Join(patterns, "|"))
+	return func(err *ExitError) bool {
+		return len(patterns) > 0 && rgx.MatchString(err.Stderr)
+	}
+}
+
+// backoff returns how long to wait before retry number retry, starting at 1
+func (r *RetryPolicy) backoff(retry int) time.Duration {
+	wait := r.InitialBackoff
+	if wait <= 0 {
+		wait = time.Second
+	}
+	maxWait := r.MaxBackoff
+	if maxWait <= 0 {
+		maxWait = 30 * time.Second
+	}
+	for i := 1; i < retry && wait < maxWait; i++ {
+		wait *= 2
+	}
+	if wait > maxWait {
+		wait = maxWait
+	}
+	//nolint:gosec
+	return wait - time.Duration(rand.Int63n(int64(wait)/2+1))
+}
+
+func (r *RetryPolicy) retryable(err error) bool {
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) {
+		return false
+	}
+	return r.Retryable == nil || r.Retryable(exitErr)
+}
+
+// WithRetry reruns the pipeline with policy when it fails.  Stdin is read into memory so every attempt gets all of it,
+// and stdout is kept in memory until the last attempt is done, unless it is a terminal.  The policy of the command
+// closest to the end of the pipeline wins.
+func (p *PipedCmd) WithRetry(policy RetryPolicy) *PipedCmd {
+	p.retry = &policy
+	return p
+}
+
+func (p *PipedCmd) retryPolicy() *RetryPolicy {
+	for current := p; current != nil; current = current.readFrom {
+		if current.retry != nil {
+			return current.retry
+		}
+	}
+	return nil
+}
+
+// executeWithRetry runs the pipeline until it passes, fails in a way policy does not retry, runs out of attempts or
+// ctx is canceled
+func (p *PipedCmd) executeWithRetry(ctx context.Context, policy *RetryPolicy, stdin io.Reader, stdout io.Writer, stderr io.Writer) ([]StageStatus, error) {
+	var input []byte
+	if stdin != nil {
+		var err error
+		if input, err = io.ReadAll(stdin); err != nil {
+			return nil, fmt.Errorf("unable to read stdin: %w", err)
+		}
+	}
+	for attempt := 1; ; attempt++ {
+		if stdin != nil {
+			stdin = bytes.NewReader(input)
+		}
+		// Only the output of the last attempt is kept, so a failed attempt cannot leave half its output in front of
+		// the next one.  A terminal shows the output as it comes instead
+		attemptStdout := stdout
+		var buffered bytes.Buffer
+		if stdout != nil && !isTerminal(stdout) {
+			attemptStdout = &buffered
+		}
+		statuses, err := p.executeOnce(ctx, stdin, attemptStdout, stderr)
+		if err == nil || attempt >= policy.Attempts || !policy.retryable(err) {
+			if attemptStdout == &buffered {
+				if _, copyErr := buffered.WriteTo(stdout); copyErr != nil && err == nil {
+					err = fmt.Errorf("unable to write output: %w", copyErr)
+				}
+			}
+			return statuses, err
+		}
+		wait := policy.backoff(attempt)
+		log.Printf("Attempt %d of %d failed, retrying in %s: %v", attempt, policy.Attempts, wait.Round(time.Millisecond), err)
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return statuses, errors.Join(err, ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
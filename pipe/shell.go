@@ -0,0 +1,279 @@
+package pipe
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/cresta/magehelper/env"
+	"mvdan.cc/sh/v3/expand"
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// Shell parses fullLine with the grammar of bash into commands that run directly, without /bin/sh.  It
+// supports
+//
+//	Shell("echo hi")
+//	Shell("GOOS=linux go build")
+//	Shell("docker run -v $HOME/.aws:/root/.aws:ro ubuntu")
+//	Shell("echo '$HOME' \"$HOME\"")
+//	Shell("go list ./... | grep -v /vendor/ > packages.txt 2>&1")
+//	Shell("docker inspect app >/dev/null || docker pull app")
+//	Shell("make build && make test; make clean")
+//
+// Words are expanded like the shell does, with the environment of env.Instance.  Assignments in front of a command
+// are also used to expand the rest of it.  Globs, command substitution, subshells and control flow like if are not
+// supported.  The returned PipedCmd is the last command of the line, so its With methods configure that one.
+func Shell(fullLine string) *PipedCmd {
+	ret, err := ShellWithError(fullLine)
+	if err != nil {
+		panic(err)
+	}
+	return ret
+}
+
+// ShellWithError is Shell, returning an error when fullLine cannot be parsed
+func ShellWithError(fullLine string) (*PipedCmd, error) {
+	f, err := syntax.NewParser().Parse(strings.NewReader(fullLine), "")
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse command line %s: %w", fullLine, err)
+	}
+	if len(f.Stmts) == 0 {
+		return nil, fmt.Errorf("bad command line %s", fullLine)
+	}
+	var ret *PipedCmd
+	for _, stmt := range f.Stmts {
+		next, err := shellStmt(stmt)
+		if err != nil {
+			return nil, fmt.Errorf("bad command line %s: %w", fullLine, err)
+		}
+		if ret != nil {
+			runAfter(ret, next, ";")
+		}
+		ret = next
+	}
+	return ret, nil
+}
+
+// first returns the first command of the pipeline of p
+func (p *PipedCmd) first() *PipedCmd {
+	ret := p
+	for ret.readFrom != nil {
+		ret = ret.readFrom
+	}
+	return ret
+}
+
+// runAfter makes the list of pipelines ending with next run after the one of prev, depending on op
+func runAfter(prev *PipedCmd, next *PipedCmd, op string) {
+	head := next.first()
+	for head.prev != nil {
+		head = head.prev.first()
+	}
+	head.prev, head.prevOp = prev, op
+}
+
+// shellStmt returns the last command of stmt
+func shellStmt(stmt *syntax.Stmt) (*PipedCmd, error) {
+	if stmt.Negated || stmt.Background || stmt.Coprocess {
+		return nil, fmt.Errorf("! and & statements are not supported")
+	}
+	var ret *PipedCmd
+	switch cmd := stmt.Cmd.(type) {
+	case *syntax.CallExpr:
+		var err error
+		if ret, err = shellCall(cmd); err != nil {
+			return nil, err
+		}
+	case *syntax.BinaryCmd:
+		if len(stmt.Redirs) > 0 {
+			return nil, fmt.Errorf("redirecting a list of commands is not supported")
+		}
+		return shellBinary(cmd)
+	default:
+		return nil, fmt.Errorf("unsupported command %T", stmt.Cmd)
+	}
+	for _, r := range stmt.Redirs {
+		redirects, err := shellRedirect(r)
+		if err != nil {
+			return nil, err
+		}
+		ret.redirects = append(ret.redirects, redirects...)
+	}
+	return ret, nil
+}
+
+func shellBinary(cmd *syntax.BinaryCmd) (*PipedCmd, error) {
+	left, err := shellStmt(cmd.X)
+	if err != nil {
+		return nil, err
+	}
+	right, err := shellStmt(cmd.Y)
+	if err != nil {
+		return nil, err
+	}
+	switch cmd.Op {
+	case syntax.AndStmt, syntax.OrStmt:
+		runAfter(left, right, cmd.Op.String())
+		return right, nil
+	case syntax.Pipe, syntax.PipeAll:
+		if left.first().prev != nil || right.first().prev != nil {
+			return nil, fmt.Errorf("piping lists of commands is not supported")
+		}
+		if cmd.Op == syntax.PipeAll {
+			left.redirects = append(left.redirects, redirect{fd: 2, op: ">&", target: "1"})
+		}
+		left.PipeTo(right.first())
+		return right, nil
+	default:
+		return nil, fmt.Errorf("unsupported operator %s", cmd.Op)
+	}
+}
+
+func shellCall(call *syntax.CallExpr) (*PipedCmd, error) {
+	// Assignments are used to expand the rest of the command too
+	assigned := make(map[string]string)
+	cfg := &expand.Config{
+		Env: expand.FuncEnviron(func(name string) string {
+			if v, exists := assigned[name]; exists {
+				return v
+			}
+			return env.Instance.Get(name)
+		}),
+	}
+	var envAssignments []string
+	for _, assign := range call.Assigns {
+		if assign.Append || assign.Naked || assign.Index != nil || assign.Array != nil {
+			return nil, fmt.Errorf("unsupported assignment to %s", assign.Name.Value)
+		}
+		value := ""
+		if assign.Value != nil {
+			var err error
+			if value, err = expand.Literal(cfg, assign.Value); err != nil {
+				return nil, err
+			}
+		}
+		if IsSecretEnv(assign.Name.Value) {
+			AddSecret(value)
+		}
+		assigned[assign.Name.Value] = value
+		envAssignments = append(envAssignments, assign.Name.Value+"="+value)
+	}
+	fields, err := expand.Fields(cfg, call.Args...)
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("no command to run")
+	}
+	return &PipedCmd{
+		cmd:    fields[0],
+		args:   fields[1:],
+		addEnv: envAssignments,
+	}, nil
+}
+
+// redirect changes where a file descriptor of a command goes: fd op target, like 2>&1 or > out.txt
+type redirect struct {
+	fd     int
+	op     string
+	target string
+}
+
+func (r redirect) String() string {
+	if r.op == ">&" {
+		return fmt.Sprintf("%d>&%s", r.fd, r.target)
+	}
+	if (r.op == "<" && r.fd == 0) || (r.op != "<" && r.fd == 1) {
+		return r.op + " " + formatArgs([]string{r.target})
+	}
+	return strconv.Itoa(r.fd) + r.op + " " + formatArgs([]string{r.target})
+}
+
+func shellRedirect(r *syntax.Redirect) ([]redirect, error) {
+	target, err := expand.Literal(&expand.Config{Env: expand.FuncEnviron(env.Instance.Get)}, r.Word)
+	if err != nil {
+		return nil, err
+	}
+	ret := redirect{target: target}
+	switch r.Op {
+	case syntax.RdrIn:
+		ret.fd, ret.op = 0, "<"
+	case syntax.RdrOut, syntax.ClbOut:
+		ret.fd, ret.op = 1, ">"
+	case syntax.AppOut:
+		ret.fd, ret.op = 1, ">>"
+	case syntax.DplOut:
+		ret.fd, ret.op = 1, ">&"
+		if target != "1" && target != "2" {
+			return nil, fmt.Errorf("unsupported redirect >&%s", target)
+		}
+	case syntax.RdrAll, syntax.AppAll:
+		// &> file is > file 2>&1
+		op := ">"
+		if r.Op == syntax.AppAll {
+			op = ">>"
+		}
+		return []redirect{{fd: 1, op: op, target: target}, {fd: 2, op: ">&", target: "1"}}, nil
+	default:
+		return nil, fmt.Errorf("unsupported redirect %s", r.Op)
+	}
+	if r.N != nil {
+		fd, err := strconv.Atoi(r.N.Value)
+		if err != nil || fd > 2 || (ret.op == "<") != (fd == 0) {
+			return nil, fmt.Errorf("unsupported redirect of file descriptor %s", r.N.Value)
+		}
+		ret.fd = fd
+	}
+	return []redirect{ret}, nil
+}
+
+// applyRedirects points the stdin, stdout and stderr of cmd at the redirect targets, in order, and returns the files
+// it opened
+func applyRedirects(cmd *exec.Cmd, redirects []redirect) ([]*os.File, error) {
+	var opened []*os.File
+	for _, r := range redirects {
+		if r.op == ">&" {
+			switch {
+			case r.fd == 2 && r.target == "1":
+				cmd.Stderr = cmd.Stdout
+			case r.fd == 1 && r.target == "2":
+				cmd.Stdout = cmd.Stderr
+			}
+			continue
+		}
+		name := r.target
+		if cmd.Dir != "" && !filepath.IsAbs(name) {
+			name = filepath.Join(cmd.Dir, name)
+		}
+		var f *os.File
+		var err error
+		switch r.op {
+		case "<":
+			f, err = os.Open(name)
+		case ">":
+			f, err = os.Create(name)
+		case ">>":
+			f, err = os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o666)
+		}
+		if err != nil {
+			for _, o := range opened {
+				_ = o.Close()
+			}
+			return nil, fmt.Errorf("unable to redirect %s: %w", r, err)
+		}
+		opened = append(opened, f)
+		switch r.fd {
+		case 0:
+			cmd.Stdin = f
+		case 1:
+			cmd.Stdout = f
+		case 2:
+			cmd.Stderr = f
+		}
+	}
+	return opened, nil
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package pipe
+
+// brokenPipe reports if err is from a command killed by SIGPIPE, which only exists on unix
+func brokenPipe(_ error) bool {
+	return false
+}
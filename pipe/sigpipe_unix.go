@@ -0,0 +1,25 @@
+//go:build unix
+
+package pipe
+
+import (
+	"errors"
+	"os/exec"
+	"syscall"
+)
+
+// brokenPipe reports if err is from a command killed by SIGPIPE, or a shell exiting with the status of one
+func brokenPipe(err error) bool {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return false
+	}
+	status, ok := exitErr.Sys().(syscall.WaitStatus)
+	if !ok {
+		return false
+	}
+	if status.Signaled() {
+		return status.Signal() == syscall.SIGPIPE
+	}
+	return status.ExitStatus() == 128+int(syscall.SIGPIPE)
+}
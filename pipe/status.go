@@ -0,0 +1,33 @@
+package pipe
+
+import "time"
+
+// StageStatus is how one command of a pipeline finished
+type StageStatus struct {
+	// Args is the command line, starting with the program
+	Args []string
+	// ExitCode is the exit status of the command, or -1 if it was killed by a signal
+	ExitCode int
+	Duration time.Duration
+	// BrokenPipe is set when the command was killed by SIGPIPE because a later command stopped reading, like head
+	// does.  It does not count as a failure
+	BrokenPipe bool
+	// Err is the *ExitError of a failed command, or nil
+	Err error
+}
+
+// pipelineError returns the error of the first failed stage, or of the last stage when pipefail is disabled
+func pipelineError(statuses []StageStatus, pipefail bool) error {
+	if len(statuses) == 0 {
+		return nil
+	}
+	if !pipefail {
+		return statuses[len(statuses)-1].Err
+	}
+	for _, status := range statuses {
+		if status.Err != nil {
+			return status.Err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package pipe
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// DefaultGracePeriod is how long a command has to exit after the stop signal, before it is killed.  It is short
+// enough to finish before mage gives up on cleaning up, 5 seconds after Ctrl-C
+var DefaultGracePeriod = 3 * time.Second
+
+// WithTimeout stops this command if it runs longer than timeout.  Like WithStopSignal and WithGracePeriod, it runs the
+// command in its own process group even in a terminal, and stops waiting for output held open by processes it started
+// in the background once the grace period is over
+func (p *PipedCmd) WithTimeout(timeout time.Duration) *PipedCmd {
+	p.timeout = timeout
+	return p
+}
+
+// WithStopSignal sets the signal this command gets when it times out or the context is canceled.  Defaults to
+// SIGTERM, or killing the process on platforms without signals
+func (p *PipedCmd) WithStopSignal(sig os.Signal) *PipedCmd {
+	p.stopSignal = sig
+	return p
+}
+
+// WithGracePeriod sets how long this command has to exit after the stop signal, before it and every process it
+// started are killed.  Defaults to DefaultGracePeriod
+func (p *PipedCmd) WithGracePeriod(grace time.Duration) *PipedCmd {
+	p.gracePeriod = grace
+	return p
+}
+
+// hasTerminal reports if this process runs in a terminal
+var hasTerminal = sync.OnceValue(func() bool {
+	return isTerminal(os.Stdin) || isTerminal(os.Stdout) || isTerminal(os.Stderr)
+})
+
+// processGroup reports if this command runs in its own process group, so stopping it reaches every process it starts.
+// In a terminal only when it asked for a graceful stop, since commands outside the foreground process group get
+// stopped when they read the terminal, like ssh or gpg prompts do from /dev/tty.  Never when it reads the terminal on
+// stdin
+func (p *PipedCmd) processGroup(stdin io.Reader) bool {
+	if stdin == os.Stdin {
+		return false
+	}
+	return p.stopsGracefully() || !hasTerminal()
+}
+
+// stopsGracefully reports if the command asked for a graceful stop with WithTimeout, WithStopSignal or
+// WithGracePeriod
+func (p *PipedCmd) stopsGracefully() bool {
+	return p.timeout > 0 || p.stopSignal != nil || p.gracePeriod > 0
+}
+
+// gracefulStop makes cmd get the stop signal when its context is done, and get killed after the grace period.  With
+// group set the signals go to its whole process group.  The returned function stops the kill once cmd exited.
+func (p *PipedCmd) gracefulStop(cmd *exec.Cmd, group bool) func() {
+	sig := p.stopSignal
+	if sig == nil {
+		sig = defaultStopSignal
+	}
+	grace := p.gracePeriod
+	if grace <= 0 {
+		grace = DefaultGracePeriod
+	}
+	if group {
+		setProcessGroup(cmd)
+	}
+	var mu sync.Mutex
+	var kill *time.Timer
+	cmd.Cancel = func() error {
+		mu.Lock()
+		defer mu.Unlock()
+		kill = time.AfterFunc(grace, func() {
+			_ = signalProcess(cmd, os.Kill, group)
+		})
+		return signalProcess(cmd, sig, group)
+	}
+	// Stop waiting for output held open by processes that outlive cmd, and kill cmd if the kill above did not.  Only
+	// when asked for, since it cuts off the output of background processes that are still writing
+	if p.stopsGracefully() {
+		cmd.WaitDelay = grace + time.Second
+	}
+	return func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if kill != nil {
+			kill.Stop()
+		}
+	}
+}
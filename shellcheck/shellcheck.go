@@ -3,9 +3,13 @@ package shellcheck
 import (
 	"context"
 	"fmt"
+	"os"
+	"path/filepath"
 
+	"github.com/cresta/magehelper/env"
 	"github.com/cresta/magehelper/files"
 	"github.com/cresta/magehelper/pipe"
+	"github.com/cresta/magehelper/tools"
 )
 
 type ShellCheck struct{}
@@ -14,7 +18,7 @@ var instance ShellCheck
 
 func (s *ShellCheck) Lint(ctx context.Context) error {
 	// Find all *.sh files
-	allSh, err := files.AllWithExtension(".sh")
+	allSh, err := files.Find(".").GitIgnore().Extensions(".sh").ChangedIfRequested(env.Instance).All(ctx)
 	if err != nil {
 		return err
 	}
@@ -22,10 +26,18 @@ func (s *ShellCheck) Lint(ctx context.Context) error {
 		fmt.Println("No shell scripts to lint")
 		return nil
 	}
-	return pipe.NewPiped("docker", `run`, `--rm`, `-v`, `/h/goland/magehelper:/mnt:ro`, `koalaman/shellcheck:stable`, allSh[0]).Run(ctx)
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	args := []string{`run`, `--rm`, `-v`, wd + `:/mnt:ro`, `-w`, `/mnt`, tools.Instance.Image("shellcheck", "koalaman/shellcheck:stable")}
+	for _, sh := range allSh {
+		args = append(args, filepath.ToSlash(sh))
+	}
+	return pipe.NewPiped("docker", args...).Run(ctx)
 }
 
-// Run a shellcheck lint via docker against all '*.sh' files
+// Run a shellcheck lint via docker against all '*.sh' files, or only changed ones if ${MAGEHELPER_ONLY_CHANGED} is set
 func Lint(ctx context.Context) error {
 	return instance.Lint(ctx)
 }
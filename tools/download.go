@@ -0,0 +1,138 @@
+package tools
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// maxDownload limits how large a tool download may be
+const maxDownload = 512 << 20
+
+// download fetches tool.URL, verifies its checksum and writes the executable to dir
+func download(ctx context.Context, dir string, name string, tool Tool) error {
+	platform := runtime.GOOS + "/" + runtime.GOARCH
+	want, exists := tool.Checksums[platform]
+	if !exists {
+		return fmt.Errorf("no checksum pinned for %s", platform)
+	}
+	url := os.Expand(tool.URL, func(s string) string {
+		switch s {
+		case "VERSION":
+			return tool.Version
+		case "OS":
+			return runtime.GOOS
+		case "ARCH":
+			return runtime.GOARCH
+		}
+		return "${" + s + "}"
+	})
+	content, err := fetch(ctx, url)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(content)
+	if got := hex.EncodeToString(sum[:]); !strings.EqualFold(got, want) {
+		return fmt.Errorf("checksum mismatch for %s: expected %s, got %s", url, want, got)
+	}
+	binary := tool.Binary
+	if binary == "" {
+		binary = exe(name)
+	}
+	switch {
+	case strings.HasSuffix(url, ".tar.gz") || strings.HasSuffix(url, ".tgz"):
+		content, err = fromTarGz(content, binary)
+	case strings.HasSuffix(url, ".zip"):
+		content, err = fromZip(content, binary)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to extract %s from %s: %w", binary, url, err)
+	}
+	//nolint:gosec // the tool has to be executable
+	if err := os.WriteFile(filepath.Join(dir, exe(name)), content, 0o755); err != nil {
+		return fmt.Errorf("unable to write %s: %w", name, err)
+	}
+	return nil
+}
+
+func fetch(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to download %s: %w", url, err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to download %s: %s", url, resp.Status)
+	}
+	content, err := io.ReadAll(io.LimitReader(resp.Body, maxDownload))
+	if err != nil {
+		return nil, fmt.Errorf("unable to download %s: %w", url, err)
+	}
+	return content, nil
+}
+
+// matchesBinary reports if the archive entry name is binary, either as the full path or the file name
+func matchesBinary(name string, binary string) bool {
+	name = strings.TrimPrefix(path.Clean(filepath.ToSlash(name)), "./")
+	return name == binary || (!strings.Contains(binary, "/") && path.Base(name) == binary)
+}
+
+func fromTarGz(content []byte, binary string) ([]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("not found in archive")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag == tar.TypeReg && matchesBinary(hdr.Name, binary) {
+			return io.ReadAll(io.LimitReader(tr, maxDownload))
+		}
+	}
+}
+
+func fromZip(content []byte, binary string) ([]byte, error) {
+	zr, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() || !matchesBinary(f.Name, binary) {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			_ = rc.Close()
+		}()
+		return io.ReadAll(io.LimitReader(rc, maxDownload))
+	}
+	return nil, fmt.Errorf("not found in archive")
+}
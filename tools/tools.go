@@ -0,0 +1,254 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/cresta/magehelper/env"
+	"github.com/cresta/magehelper/files"
+	"github.com/cresta/magehelper/pipe"
+)
+
+// Tool is an external program a build runs, pinned to a version
+type Tool struct {
+	// Version is the pinned version, like v1.2.3
+	Version string
+	// GoPackage installs the tool with `go install GoPackage@Version`
+	GoPackage string
+	// URL downloads the tool when GoPackage is empty.  ${VERSION}, ${OS} and ${ARCH} are replaced with Version,
+	// GOOS and GOARCH.  A .tar.gz, .tgz or .zip download is extracted.
+	URL string
+	// Checksums maps GOOS/GOARCH to the SHA-256 the download at URL must have
+	Checksums map[string]string
+	// Binary is the path of the executable inside a downloaded archive.  Defaults to the name of the tool
+	Binary string
+	// Image is the pinned docker image of tools that run in a container instead of being installed
+	Image string
+}
+
+// Default are the tools magehelper runs.  Projects change the pins by changing Tools.Pinned, or this map before any
+// target runs.
+var Default = map[string]Tool{
+	"golangci-lint": {Version: "v1.59.1", GoPackage: "github.com/golangci/golangci-lint/cmd/golangci-lint"},
+	"goimports":     {Version: "v0.22.0", GoPackage: "golang.org/x/tools/cmd/goimports"},
+	"govulncheck":   {Version: "v1.1.2", GoPackage: "golang.org/x/vuln/cmd/govulncheck"},
+	"yq":            {Version: "v4.44.2", GoPackage: "github.com/mikefarah/yq/v4"},
+	"kustomize":     {Version: "v5.4.2", GoPackage: "sigs.k8s.io/kustomize/kustomize/v5"},
+	"shellcheck":    {Version: "v0.10.0", Image: "koalaman/shellcheck:v0.10.0"},
+	"hadolint":      {Version: "v2.12.0", Image: "hadolint/hadolint:v2.12.0"},
+}
+
+type Tools struct {
+	// Dir is where tools are installed, and is first on the PATH of every pipe.PipedCmd after Require or UsePath.
+	// Defaults to ${MAGEHELPER_TOOLS_DIR}, or .bin
+	Dir string
+	// Pinned are the tools that can be installed, by name.  Defaults to Default
+	Pinned map[string]Tool
+	Env    env.Env
+
+	mu        sync.Mutex
+	installed map[string]bool
+}
+
+var Instance Tools
+
+func (t *Tools) dir() (string, error) {
+	dir := t.Dir
+	if dir == "" {
+		dir = t.Env.GetDefault("MAGEHELPER_TOOLS_DIR", ".bin")
+	}
+	return filepath.Abs(dir)
+}
+
+func (t *Tools) pinned() map[string]Tool {
+	if t.Pinned == nil {
+		return Default
+	}
+	return t.Pinned
+}
+
+// UsePath puts Dir first on the PATH of every pipe.PipedCmd, unless tools are not installed, so targets that do not
+// call Require still run the tools a previous build installed.  Require does it too.  Call it from the init function
+// of a magefile:
+//
+//	func init() {
+//		_ = tools.Instance.UsePath()
+//	}
+func (t *Tools) UsePath() error {
+	if !t.autoInstall() {
+		return nil
+	}
+	dir, err := t.dir()
+	if err != nil {
+		return err
+	}
+	return pipe.AddPath(dir)
+}
+
+// autoInstall reports if Require installs missing tools.  Set ${MAGEHELPER_INSTALL_TOOLS} to false to use the tools
+// on PATH instead
+func (t *Tools) autoInstall() bool {
+	install, err := strconv.ParseBool(t.Env.GetDefault("MAGEHELPER_INSTALL_TOOLS", "true"))
+	return err != nil || install
+}
+
+// Image returns the pinned docker image of the tool name, or fallback if it is not pinned
+func (t *Tools) Image(name string, fallback string) string {
+	if tool, exists := t.pinned()[name]; exists && tool.Image != "" {
+		return tool.Image
+	}
+	return fallback
+}
+
+// Require makes sure the pinned version of each tool is installed in Dir, and puts Dir first on the PATH of every
+// pipe.PipedCmd.  Tools that are not pinned, or that run as an image, are left to PATH.
+func (t *Tools) Require(ctx context.Context, names ...string) error {
+	if !t.autoInstall() {
+		return nil
+	}
+	if err := t.UsePath(); err != nil {
+		return err
+	}
+	for _, name := range names {
+		tool, exists := t.pinned()[name]
+		if !exists || tool.Image != "" {
+			continue
+		}
+		if err := t.install(ctx, name, tool); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Install installs the pinned version of every tool that is not a docker image
+func (t *Tools) Install(ctx context.Context) error {
+	names := make([]string, 0, len(t.pinned()))
+	for name := range t.pinned() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if tool := t.pinned()[name]; tool.Image == "" {
+			if err := t.install(ctx, name, tool); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// versionFile records which version of a tool is installed in dir
+func versionFile(dir string, name string) string {
+	return filepath.Join(dir, "."+name+".version")
+}
+
+func (t *Tools) install(ctx context.Context, name string, tool Tool) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.installed[name] {
+		return nil
+	}
+	dir, err := t.dir()
+	if err != nil {
+		return err
+	}
+	if current, err := os.ReadFile(versionFile(dir, name)); err == nil && strings.TrimSpace(string(current)) == tool.Version && files.FileExists(filepath.Join(dir, exe(name))) {
+		t.markInstalled(name)
+		return nil
+	}
+	if pipe.DryRun() {
+		fmt.Printf("Dry run: install %s %s into %s\n", name, tool.Version, dir)
+		t.markInstalled(name)
+		return nil
+	}
+	if err := createDir(dir); err != nil {
+		return err
+	}
+	fmt.Printf("Installing %s %s into %s\n", name, tool.Version, dir)
+	switch {
+	case tool.GoPackage != "":
+		err = goInstall(ctx, &t.Env, dir, name, tool)
+	case tool.URL != "":
+		err = download(ctx, dir, name, tool)
+	default:
+		err = fmt.Errorf("no GoPackage or URL to install it from")
+	}
+	if err != nil {
+		return fmt.Errorf("unable to install %s %s: %w", name, tool.Version, err)
+	}
+	if err := os.WriteFile(versionFile(dir, name), []byte(tool.Version+"\n"), 0o600); err != nil {
+		return fmt.Errorf("unable to record version of %s: %w", name, err)
+	}
+	t.markInstalled(name)
+	return nil
+}
+
+func (t *Tools) markInstalled(name string) {
+	if t.installed == nil {
+		t.installed = make(map[string]bool)
+	}
+	t.installed[name] = true
+}
+
+// createDir creates dir with a .gitignore that ignores everything in it, so projects do not have to
+func createDir(dir string) error {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return fmt.Errorf("unable to create %s: %w", dir, err)
+	}
+	ignore := filepath.Join(dir, ".gitignore")
+	if _, err := os.Stat(ignore); err == nil {
+		return nil
+	}
+	if err := os.WriteFile(ignore, []byte("*\n"), 0o600); err != nil {
+		return fmt.Errorf("unable to write %s: %w", ignore, err)
+	}
+	return nil
+}
+
+var majorVersion = regexp.MustCompile(`^v[0-9]+$`)
+
+// goInstall runs go install with GOBIN set to dir, and renames the binary to name if go named it differently
+func goInstall(ctx context.Context, e *env.Env, dir string, name string, tool Tool) error {
+	err := pipe.NewPiped("go", "install", tool.GoPackage+"@"+tool.Version).
+		WithEnv(e.AddEnv("GOBIN=" + dir)).
+		Run(ctx)
+	if err != nil {
+		return err
+	}
+	// go install names the binary after the last element of the package that is not a major version
+	built := path.Base(tool.GoPackage)
+	if majorVersion.MatchString(built) {
+		built = path.Base(path.Dir(tool.GoPackage))
+	}
+	if built == name {
+		return nil
+	}
+	return os.Rename(filepath.Join(dir, exe(built)), filepath.Join(dir, exe(name)))
+}
+
+func exe(name string) string {
+	if runtime.GOOS == "windows" {
+		return name + ".exe"
+	}
+	return name
+}
+
+// Require installs the pinned version of each tool.  See Tools.Require
+func Require(ctx context.Context, names ...string) error {
+	return Instance.Require(ctx, names...)
+}
+
+// Install the pinned version of every tool into ${MAGEHELPER_TOOLS_DIR}
+func Install(ctx context.Context) error {
+	return Instance.Install(ctx)
+}
@@ -0,0 +1,107 @@
+package tools
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/cresta/magehelper/pipe"
+	"github.com/stretchr/testify/require"
+)
+
+func tarGz(t *testing.T, name string, content string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0o755, Size: int64(len(content)), Typeflag: tar.TypeReg}))
+	_, err := tw.Write([]byte(content))
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+func TestTools_UsePath(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a shell script")
+	}
+	// Tools installed by an earlier build are found without calling Require
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "installed-before"), []byte("#!/bin/sh\n"), 0o700))
+	tools := Tools{Dir: dir}
+	require.NoError(t, tools.UsePath())
+	found, err := pipe.LookPath("installed-before")
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(dir, "installed-before"), found)
+}
+
+func TestTools_Require(t *testing.T) {
+	archive := tarGz(t, "hello-v1.0.0/hello", "#!/bin/sh\necho hello\n")
+	var requested string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = r.URL.Path
+		_, _ = w.Write(archive)
+	}))
+	defer server.Close()
+	sum := sha256.Sum256(archive)
+	platform := runtime.GOOS + "/" + runtime.GOARCH
+	dir := t.TempDir()
+	tools := Tools{
+		Dir: dir,
+		Pinned: map[string]Tool{
+			"hello": {
+				Version:   "v1.0.0",
+				URL:       server.URL + "/hello-${VERSION}-${OS}-${ARCH}.tar.gz",
+				Checksums: map[string]string{platform: hex.EncodeToString(sum[:])},
+			},
+			"bad": {
+				Version:   "v1.0.0",
+				URL:       server.URL + "/bad.tar.gz",
+				Checksums: map[string]string{platform: "00"},
+			},
+		},
+	}
+	require.NoError(t, tools.Require(context.Background(), "hello", "not-pinned"))
+	require.Equal(t, "/hello-v1.0.0-"+runtime.GOOS+"-"+runtime.GOARCH+".tar.gz", requested)
+	content, err := os.ReadFile(filepath.Join(dir, exe("hello")))
+	require.NoError(t, err)
+	require.Equal(t, "#!/bin/sh\necho hello\n", string(content))
+	if runtime.GOOS != "windows" {
+		found, err := pipe.LookPath("hello")
+		require.NoError(t, err)
+		require.Equal(t, filepath.Join(dir, "hello"), found)
+	}
+
+	// A deleted binary is installed again, even though its version file is still there
+	require.NoError(t, os.Remove(filepath.Join(dir, exe("hello"))))
+	requested = ""
+	again := Tools{Dir: dir, Pinned: tools.Pinned}
+	require.NoError(t, again.Require(context.Background(), "hello"))
+	require.NotEmpty(t, requested)
+	require.FileExists(t, filepath.Join(dir, exe("hello")))
+
+	err = tools.Require(context.Background(), "bad")
+	require.ErrorContains(t, err, "checksum mismatch")
+	require.NoFileExists(t, filepath.Join(dir, exe("bad")))
+}
+
+func TestTools_RequireDryRun(t *testing.T) {
+	t.Setenv("MAGEHELPER_DRY_RUN", "true")
+	dir := filepath.Join(t.TempDir(), "bin")
+	tools := Tools{
+		Dir:    dir,
+		Pinned: map[string]Tool{"hello": {Version: "v1.0.0", GoPackage: "example.com/hello"}},
+	}
+	require.NoError(t, tools.Require(context.Background(), "hello"))
+	// Nothing was installed, so nothing may claim it was
+	require.NoDirExists(t, dir)
+}
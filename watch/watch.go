@@ -0,0 +1,300 @@
+package watch
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/cresta/magehelper/docker"
+	"github.com/cresta/magehelper/files"
+	"github.com/cresta/magehelper/gobuild"
+)
+
+// GoPatterns are the files that change the result of building or testing Go code
+var GoPatterns = []string{"*.go", "go.mod", "go.sum"}
+
+var (
+	// GoTest reruns gobuild.Test when Go code changes
+	GoTest = Rule{Name: "go:test", Patterns: GoPatterns, Target: gobuild.Test}
+	// GoBuild reruns gobuild.Build when Go code changes
+	GoBuild = Rule{Name: "go:build", Patterns: GoPatterns, Target: gobuild.Build}
+	// DockerBuild reruns docker.Build when any file that is not ignored changes
+	DockerBuild = Rule{Name: "docker:build", Target: docker.Build}
+)
+
+// Rule is a target to rerun when files matching its patterns change
+type Rule struct {
+	Name string
+	// Patterns are globs, as used by files.Match, of the files that trigger the target.  Empty matches any file
+	Patterns []string
+	Target   func(ctx context.Context) error
+}
+
+func (r *Rule) matches(path string) bool {
+	if len(r.Patterns) == 0 {
+		return true
+	}
+	for _, p := range r.Patterns {
+		if files.Match(p, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// Watcher polls a tree for content changes and reruns the rules matching the changed files.  A run still in
+// progress when more changes arrive is canceled through its context.
+type Watcher struct {
+	// Root is the directory to watch.  Defaults to the current directory
+	Root  string
+	Rules []Rule
+	// Interval is how often the tree is checked for changes.  Defaults to 500ms
+	Interval time.Duration
+	// Debounce is how long the tree must be quiet after a change before the rules run.  Defaults to 300ms
+	Debounce time.Duration
+	// Find creates the files.Finder listing the files to watch.  Defaults to all files not ignored by git
+	Find func(root string) *files.Finder
+}
+
+var Instance = &Watcher{
+	Rules: []Rule{GoTest},
+}
+
+func (w *Watcher) root() string {
+	if w.Root == "" {
+		return "."
+	}
+	return w.Root
+}
+
+func (w *Watcher) interval() time.Duration {
+	if w.Interval <= 0 {
+		return 500 * time.Millisecond
+	}
+	return w.Interval
+}
+
+func (w *Watcher) debounce() time.Duration {
+	if w.Debounce <= 0 {
+		return 300 * time.Millisecond
+	}
+	return w.Debounce
+}
+
+func (w *Watcher) finder() *files.Finder {
+	if w.Find != nil {
+		return w.Find(w.root())
+	}
+	return files.Find(w.root()).GitIgnore()
+}
+
+type fileState struct {
+	size    int64
+	modTime time.Time
+	hash    [sha256.Size]byte
+}
+
+// snapshot hashes every watched file.  Files whose size and modification time match prev are not read again.
+func (w *Watcher) snapshot(ctx context.Context, prev map[string]fileState) (map[string]fileState, error) {
+	ret := make(map[string]fileState, len(prev))
+	for found := range w.finder().Stream(ctx) {
+		if found.Err != nil {
+			return nil, found.Err
+		}
+		path := filepath.Join(w.root(), found.Path)
+		info, err := os.Stat(path)
+		if err != nil {
+			// Removed while walking: the next snapshot will notice
+			continue
+		}
+		if old, exists := prev[found.Path]; exists && old.size == info.Size() && old.modTime.Equal(info.ModTime()) {
+			ret[found.Path] = old
+			continue
+		}
+		hash, err := hashFile(path)
+		if err != nil {
+			continue
+		}
+		ret[found.Path] = fileState{size: info.Size(), modTime: info.ModTime(), hash: hash}
+	}
+	return ret, ctx.Err()
+}
+
+func hashFile(path string) ([sha256.Size]byte, error) {
+	var ret [sha256.Size]byte
+	f, err := os.Open(path)
+	if err != nil {
+		return ret, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return ret, err
+	}
+	copy(ret[:], h.Sum(nil))
+	return ret, nil
+}
+
+// changedPaths returns every path added, removed or with different content between two snapshots
+func changedPaths(prev map[string]fileState, cur map[string]fileState) []string {
+	var ret []string
+	for path, state := range cur {
+		if old, exists := prev[path]; !exists || old.hash != state.hash {
+			ret = append(ret, path)
+		}
+	}
+	for path := range prev {
+		if _, exists := cur[path]; !exists {
+			ret = append(ret, path)
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// Watch runs every rule once, then reruns rules whenever files matching them change, until ctx is canceled
+func (w *Watcher) Watch(ctx context.Context) error {
+	if len(w.Rules) == 0 {
+		return errors.New("no rules to watch for")
+	}
+	prev, err := w.snapshot(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("unable to read %s: %w", w.root(), err)
+	}
+	pending := make(map[int]bool, len(w.Rules))
+	for idx := range w.Rules {
+		pending[idx] = true
+	}
+	ticker := time.NewTicker(w.interval())
+	defer ticker.Stop()
+	// Start the first run right away
+	debounce := time.After(0)
+	current := &inFlight{}
+	defer func() {
+		current.stop()
+	}()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			cur, err := w.snapshot(ctx, prev)
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				fmt.Printf("watch: unable to read %s: %s\n", w.root(), err)
+				continue
+			}
+			changed := changedPaths(prev, cur)
+			prev = cur
+			triggered := false
+			for idx := range w.Rules {
+				for _, path := range changed {
+					if w.Rules[idx].matches(path) {
+						pending[idx] = true
+						triggered = true
+						break
+					}
+				}
+			}
+			if triggered {
+				debounce = time.After(w.debounce())
+			}
+		case <-debounce:
+			debounce = nil
+			// Rules that were stopped before they finished run again
+			for _, idx := range current.stop() {
+				pending[idx] = true
+			}
+			current = w.start(ctx, sortedRules(pending))
+			pending = make(map[int]bool, len(w.Rules))
+		}
+	}
+}
+
+func sortedRules(pending map[int]bool) []int {
+	ret := make([]int, 0, len(pending))
+	for idx := range pending {
+		ret = append(ret, idx)
+	}
+	sort.Ints(ret)
+	return ret
+}
+
+// inFlight is a run of rules that may still be going
+type inFlight struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+	// unfinished are the rules the run did not finish, set before done is closed
+	unfinished []int
+}
+
+// stop cancels the run, waits for it to return, and returns the rules it did not finish
+func (f *inFlight) stop() []int {
+	if f.cancel == nil {
+		return nil
+	}
+	f.cancel()
+	<-f.done
+	return f.unfinished
+}
+
+func (w *Watcher) start(ctx context.Context, rules []int) *inFlight {
+	runCtx, cancel := context.WithCancel(ctx)
+	ret := &inFlight{
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go w.run(runCtx, rules, ret)
+	return ret
+}
+
+func (w *Watcher) run(ctx context.Context, rules []int, f *inFlight) {
+	defer close(f.done)
+	for i, idx := range rules {
+		if ctx.Err() != nil {
+			f.unfinished = rules[i:]
+			return
+		}
+		rule := w.Rules[idx]
+		fmt.Printf("watch: running %s\n", rule.Name)
+		start := time.Now()
+		err := rule.Target(ctx)
+		if ctx.Err() != nil {
+			fmt.Printf("watch: %s canceled\n", rule.Name)
+			f.unfinished = rules[i:]
+			return
+		}
+		if err != nil {
+			fmt.Printf("watch: %s failed after %s: %s\n", rule.Name, time.Since(start).Round(time.Millisecond), err)
+			continue
+		}
+		fmt.Printf("watch: %s succeeded after %s\n", rule.Name, time.Since(start).Round(time.Millisecond))
+	}
+}
+
+// Watch the current directory and rerun the configured rules (go:test by default) when files change
+func Watch(ctx context.Context) error {
+	return Instance.Watch(ctx)
+}
+
+// Watch the current directory and rerun go:test when Go code changes
+func Test(ctx context.Context) error {
+	w := &Watcher{Rules: []Rule{GoTest}}
+	return w.Watch(ctx)
+}
+
+// Watch the current directory and rerun go:build when Go code changes
+func Build(ctx context.Context) error {
+	w := &Watcher{Rules: []Rule{GoBuild}}
+	return w.Watch(ctx)
+}
@@ -0,0 +1,97 @@
+package watch
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatcher(t *testing.T) {
+	root := t.TempDir()
+	goFile := filepath.Join(root, "main.go")
+	require.NoError(t, os.WriteFile(goFile, []byte("package main"), 0o600))
+	var goRuns, otherRuns atomic.Int32
+	w := &Watcher{
+		Root:     root,
+		Interval: 10 * time.Millisecond,
+		Debounce: 20 * time.Millisecond,
+		Rules: []Rule{
+			{Name: "go", Patterns: GoPatterns, Target: func(ctx context.Context) error {
+				goRuns.Add(1)
+				return nil
+			}},
+			{Name: "md", Patterns: []string{"*.md"}, Target: func(ctx context.Context) error {
+				otherRuns.Add(1)
+				return nil
+			}},
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- w.Watch(ctx)
+	}()
+	require.Eventually(t, func() bool { return goRuns.Load() == 1 && otherRuns.Load() == 1 }, time.Second, 5*time.Millisecond)
+
+	// Same content: not a change
+	require.NoError(t, os.WriteFile(goFile, []byte("package main"), 0o600))
+	time.Sleep(5 * (w.Interval + w.Debounce))
+	require.Equal(t, int32(1), goRuns.Load())
+
+	require.NoError(t, os.WriteFile(goFile, []byte("package main\n"), 0o600))
+	require.Eventually(t, func() bool { return goRuns.Load() == 2 }, time.Second, 5*time.Millisecond)
+	require.Equal(t, int32(1), otherRuns.Load())
+
+	cancel()
+	require.NoError(t, <-done)
+}
+
+func TestWatcherRequeuesCanceledRules(t *testing.T) {
+	root := t.TempDir()
+	var goRuns, goFinished, mdRuns atomic.Int32
+	w := &Watcher{
+		Root:     root,
+		Interval: 10 * time.Millisecond,
+		Debounce: 20 * time.Millisecond,
+		Rules: []Rule{
+			{Name: "go", Patterns: GoPatterns, Target: func(ctx context.Context) error {
+				// The first run only ends when it is canceled
+				if goRuns.Add(1) == 1 {
+					<-ctx.Done()
+					return ctx.Err()
+				}
+				goFinished.Add(1)
+				return nil
+			}},
+			{Name: "md", Patterns: []string{"*.md"}, Target: func(ctx context.Context) error {
+				mdRuns.Add(1)
+				return nil
+			}},
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- w.Watch(ctx)
+	}()
+	require.Eventually(t, func() bool { return goRuns.Load() == 1 }, time.Second, 5*time.Millisecond)
+
+	// A change that only triggers md cancels the go run, which must run again
+	require.NoError(t, os.WriteFile(filepath.Join(root, "README.md"), []byte("# hi"), 0o600))
+	require.Eventually(t, func() bool { return goFinished.Load() == 1 && mdRuns.Load() == 1 }, time.Second, 5*time.Millisecond)
+	require.Equal(t, int32(2), goRuns.Load())
+
+	cancel()
+	require.NoError(t, <-done)
+}
+
+func TestChangedPaths(t *testing.T) {
+	prev := map[string]fileState{"same": {hash: [32]byte{1}}, "modified": {hash: [32]byte{1}}, "removed": {}}
+	cur := map[string]fileState{"same": {hash: [32]byte{1}, size: 5}, "modified": {hash: [32]byte{2}}, "added": {}}
+	require.Equal(t, []string{"added", "modified", "removed"}, changedPaths(prev, cur))
+}
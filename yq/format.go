@@ -0,0 +1,124 @@
+package yq
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SequenceStyle controls how Format writes YAML sequences
+type SequenceStyle int
+
+const (
+	// SequenceBlock writes every sequence in block style, one "- item" per line.  This matches `yq -P`
+	SequenceBlock SequenceStyle = iota
+	// SequencePreserve keeps flow sequences, like [a, b], as they were written
+	SequencePreserve
+	// SequenceFlowScalars writes sequences that only contain scalars in flow style and everything else in block style
+	SequenceFlowScalars
+)
+
+const defaultIndent = 2
+
+// FormatConfig configures the native YAML formatter.  The zero value formats like `yq -P`
+type FormatConfig struct {
+	// Indent is the number of spaces used for each indentation level.  Defaults to 2
+	Indent int
+	// Sequences is the style used to write sequences
+	Sequences SequenceStyle
+}
+
+func (f FormatConfig) indent() int {
+	if f.Indent <= 0 {
+		return defaultIndent
+	}
+	return f.Indent
+}
+
+// Format reformats YAML content in process.  Comments, key order and every document of a multi-document input are
+// kept.  Mappings are always written in block style.  Input without any documents (empty, or only comments) is
+// returned unchanged.
+func Format(in []byte, config FormatConfig) ([]byte, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(in))
+	var out bytes.Buffer
+	enc := yaml.NewEncoder(&out)
+	enc.SetIndent(config.indent())
+	docs := 0
+	for {
+		var doc yaml.Node
+		if err := dec.Decode(&doc); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, fmt.Errorf("unable to parse document %d: %w", docs+1, err)
+		}
+		if isEmptyDocument(&doc) {
+			continue
+		}
+		config.restyle(&doc)
+		if err := enc.Encode(&doc); err != nil {
+			return nil, fmt.Errorf("unable to encode document %d: %w", docs+1, err)
+		}
+		docs++
+	}
+	if docs == 0 {
+		return in, nil
+	}
+	if err := enc.Close(); err != nil {
+		return nil, fmt.Errorf("unable to finish encoding: %w", err)
+	}
+	if bytes.HasPrefix(in, []byte("---\n")) {
+		// Keep an explicit leading document marker, which the encoder never writes for the first document
+		return append([]byte("---\n"), out.Bytes()...), nil
+	}
+	return out.Bytes(), nil
+}
+
+func isEmptyDocument(doc *yaml.Node) bool {
+	if doc.HeadComment != "" || doc.LineComment != "" || doc.FootComment != "" {
+		return false
+	}
+	if len(doc.Content) == 0 {
+		return true
+	}
+	if len(doc.Content) > 1 {
+		return false
+	}
+	c := doc.Content[0]
+	return c.Kind == yaml.ScalarNode && c.Tag == "!!null" && c.Value == "" &&
+		c.HeadComment == "" && c.LineComment == "" && c.FootComment == ""
+}
+
+func (f FormatConfig) restyle(n *yaml.Node) {
+	switch n.Kind {
+	case yaml.MappingNode:
+		n.Style &^= yaml.FlowStyle
+	case yaml.SequenceNode:
+		switch f.Sequences {
+		case SequenceBlock:
+			n.Style &^= yaml.FlowStyle
+		case SequenceFlowScalars:
+			if len(n.Content) > 0 && onlyScalars(n.Content) {
+				n.Style |= yaml.FlowStyle
+			} else {
+				n.Style &^= yaml.FlowStyle
+			}
+		case SequencePreserve:
+		}
+	}
+	for _, c := range n.Content {
+		f.restyle(c)
+	}
+}
+
+func onlyScalars(nodes []*yaml.Node) bool {
+	for _, n := range nodes {
+		if n.Kind != yaml.ScalarNode || n.HeadComment != "" || n.LineComment != "" || n.FootComment != "" {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,56 @@
+package yq
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormat(t *testing.T) {
+	in := `# header
+b: 1 # trailing
+a: {x: 1, y: [1, 2]}
+---
+list:
+    - one
+    - two
+`
+	out, err := Format([]byte(in), FormatConfig{})
+	require.NoError(t, err)
+	require.Equal(t, `# header
+b: 1 # trailing
+a:
+  x: 1
+  y:
+    - 1
+    - 2
+---
+list:
+  - one
+  - two
+`, string(out))
+}
+
+func TestFormat_Sequences(t *testing.T) {
+	in := "a: [1, 2]\nb:\n  - x\n  - y: 1\n"
+	out, err := Format([]byte(in), FormatConfig{Sequences: SequencePreserve, Indent: 4})
+	require.NoError(t, err)
+	require.Equal(t, "a: [1, 2]\nb:\n    - x\n    - y: 1\n", string(out))
+
+	out, err = Format([]byte("a:\n  - x\n  - y\nb:\n  - z: 1\n"), FormatConfig{Sequences: SequenceFlowScalars})
+	require.NoError(t, err)
+	require.Equal(t, "a: [x, y]\nb:\n  - z: 1\n", string(out))
+}
+
+func TestFormat_NoDocuments(t *testing.T) {
+	for _, in := range []string{"", "# only a comment\n", "---\n"} {
+		out, err := Format([]byte(in), FormatConfig{})
+		require.NoError(t, err)
+		require.Equal(t, in, string(out))
+	}
+}
+
+func TestFormat_Invalid(t *testing.T) {
+	_, err := Format([]byte("a: [1, 2\n"), FormatConfig{})
+	require.Error(t, err)
+}
@@ -0,0 +1,380 @@
+package yq
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cresta/magehelper/files"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	// Allows schemas to be loaded from http and https URLs
+	_ "github.com/santhosh-tekuri/jsonschema/v5/httploader"
+	"gopkg.in/yaml.v3"
+)
+
+// SchemaStore maps common YAML files to their schema on https://www.schemastore.org.  Set Yq.Schemas to it (or a
+// copy extended with your own schemas) to validate them.
+var SchemaStore = map[string]string{
+	".github/workflows/*.yaml": "https://json.schemastore.org/github-workflow.json",
+	".github/workflows/*.yml":  "https://json.schemastore.org/github-workflow.json",
+	".github/dependabot.yml":   "https://json.schemastore.org/dependabot-2.0.json",
+	"kustomization.yaml":       "https://json.schemastore.org/kustomization.json",
+	"kustomization.yml":        "https://json.schemastore.org/kustomization.json",
+}
+
+// Problem is a single issue found while validating a YAML file
+type Problem struct {
+	File    string
+	Line    int
+	Message string
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%s:%d: %s", p.File, p.Line, p.Message)
+}
+
+var (
+	yamlErrLine        = regexp.MustCompile(`^yaml: line (\d+): (.*)$`)
+	badSeparator       = regexp.MustCompile(`^(-{2}|-{4,})\s*$`)
+	separatorWithText  = regexp.MustCompile(`^---[^\s]`)
+	schemaLocationPart = strings.NewReplacer("~1", "/", "~0", "~")
+)
+
+// LintContent checks the YAML content of file for tabs, invalid document separators, parse errors, duplicate keys and
+// nested blocks that are not indented by config.Indent spaces.
+func LintContent(file string, content []byte, config FormatConfig) []Problem {
+	var problems []Problem
+	docs, err := parseDocuments(content)
+	if err != nil {
+		problems = append(problems, parseProblem(file, err))
+	}
+	lines := strings.Split(string(content), "\n")
+	inBlockScalar := make(map[int]bool)
+	for _, doc := range docs {
+		blockScalarLines(doc, lines, inBlockScalar)
+	}
+	for idx, line := range lines {
+		line = strings.TrimSuffix(line, "\r")
+		// Tabs and dashes are just text in the content of | and > scalars
+		if inBlockScalar[idx] {
+			continue
+		}
+		switch {
+		case strings.Contains(leadingWhitespace(line), "\t"):
+			problems = append(problems, Problem{File: file, Line: idx + 1, Message: "tab character used for indentation"})
+		case badSeparator.MatchString(line):
+			problems = append(problems, Problem{File: file, Line: idx + 1, Message: fmt.Sprintf("invalid document separator %q, expected ---", strings.TrimSpace(line))})
+		case separatorWithText.MatchString(line):
+			problems = append(problems, Problem{File: file, Line: idx + 1, Message: "document separator must be followed by a space or the end of the line"})
+		}
+	}
+	for _, doc := range docs {
+		problems = append(problems, lintNode(file, doc, config.indent())...)
+	}
+	sort.SliceStable(problems, func(i, j int) bool {
+		return problems[i].Line < problems[j].Line
+	})
+	return problems
+}
+
+// blockScalarLines marks the indexes of lines that hold the content of the literal and folded scalars under n.  The
+// content starts after the line of the | or > indicator, and runs while lines are blank or indented at least as far as
+// its first line
+func blockScalarLines(n *yaml.Node, lines []string, marked map[int]bool) {
+	if n.Kind == yaml.ScalarNode && n.Style&(yaml.LiteralStyle|yaml.FoldedStyle) != 0 {
+		indent := -1
+		for idx := n.Line; idx < len(lines); idx++ {
+			line := strings.TrimSuffix(lines[idx], "\r")
+			spaces := len(line) - len(strings.TrimLeft(line, " "))
+			if strings.TrimSpace(line) != "" {
+				if indent < 0 {
+					indent = spaces
+				}
+				if spaces < indent {
+					break
+				}
+			}
+			marked[idx] = true
+		}
+	}
+	for _, c := range n.Content {
+		blockScalarLines(c, lines, marked)
+	}
+}
+
+// leadingWhitespace returns the spaces and tabs line starts with, or "" for a line without anything else
+func leadingWhitespace(line string) string {
+	content := strings.TrimLeft(line, " \t")
+	if content == "" {
+		return ""
+	}
+	return line[:len(line)-len(content)]
+}
+
+func parseDocuments(content []byte) ([]*yaml.Node, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(content))
+	var docs []*yaml.Node
+	for {
+		var doc yaml.Node
+		if err := dec.Decode(&doc); err != nil {
+			if errors.Is(err, io.EOF) {
+				return docs, nil
+			}
+			return docs, err
+		}
+		docs = append(docs, &doc)
+	}
+}
+
+func parseProblem(file string, err error) Problem {
+	if m := yamlErrLine.FindStringSubmatch(err.Error()); m != nil {
+		line, _ := strconv.Atoi(m[1])
+		return Problem{File: file, Line: line, Message: m[2]}
+	}
+	return Problem{File: file, Line: 1, Message: err.Error()}
+}
+
+func lintNode(file string, n *yaml.Node, indent int) []Problem {
+	var problems []Problem
+	if n.Kind == yaml.MappingNode {
+		seen := make(map[string]int, len(n.Content)/2)
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key, value := n.Content[i], n.Content[i+1]
+			if key.Kind == yaml.ScalarNode && key.Value != "<<" {
+				if first, exists := seen[key.Value]; exists {
+					problems = append(problems, Problem{File: file, Line: key.Line, Message: fmt.Sprintf("duplicate key %q, first defined on line %d", key.Value, first)})
+				} else {
+					seen[key.Value] = key.Line
+				}
+			}
+			if p, ok := checkIndent(file, n, key, value, indent); !ok {
+				problems = append(problems, p)
+			}
+		}
+	}
+	for _, c := range n.Content {
+		problems = append(problems, lintNode(file, c, indent)...)
+	}
+	return problems
+}
+
+// checkIndent verifies a block collection nested under key starts indent columns to the right of the key.  Block
+// sequences may also start at the same column as the key.
+func checkIndent(file string, parent *yaml.Node, key *yaml.Node, value *yaml.Node, indent int) (Problem, bool) {
+	if parent.Style&yaml.FlowStyle != 0 || value.Style&yaml.FlowStyle != 0 || value.Line == key.Line || len(value.Content) == 0 {
+		return Problem{}, true
+	}
+	got := value.Column - key.Column
+	switch value.Kind {
+	case yaml.MappingNode:
+		if got == indent {
+			return Problem{}, true
+		}
+	case yaml.SequenceNode:
+		if got == indent || got == 0 {
+			return Problem{}, true
+		}
+	default:
+		return Problem{}, true
+	}
+	return Problem{File: file, Line: value.Line, Message: fmt.Sprintf("wrong indentation under %q: expected %d spaces but found %d", key.Value, indent, got)}, false
+}
+
+// ValidateSchema validates every document of content against schema, reporting problems on the line of the value
+// that failed validation.
+func ValidateSchema(file string, content []byte, schema *jsonschema.Schema) []Problem {
+	docs, err := parseDocuments(content)
+	if err != nil {
+		return []Problem{parseProblem(file, err)}
+	}
+	var problems []Problem
+	for _, doc := range docs {
+		if isEmptyDocument(doc) {
+			continue
+		}
+		value, err := jsonValue(doc)
+		if err != nil {
+			problems = append(problems, Problem{File: file, Line: doc.Line, Message: err.Error()})
+			continue
+		}
+		err = schema.Validate(value)
+		var validationErr *jsonschema.ValidationError
+		if errors.As(err, &validationErr) {
+			for _, leaf := range leafErrors(validationErr) {
+				problems = append(problems, Problem{
+					File:    file,
+					Line:    lineAt(doc, leaf.InstanceLocation),
+					Message: fmt.Sprintf("%s: %s", displayLocation(leaf.InstanceLocation), leaf.Message),
+				})
+			}
+		} else if err != nil {
+			problems = append(problems, Problem{File: file, Line: doc.Line, Message: err.Error()})
+		}
+	}
+	return problems
+}
+
+// jsonValue converts a YAML document into the plain JSON types the schema validator expects
+func jsonValue(doc *yaml.Node) (any, error) {
+	var raw any
+	if err := doc.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("unable to decode document: %w", err)
+	}
+	b, err := json.Marshal(stringKeys(raw))
+	if err != nil {
+		return nil, fmt.Errorf("document cannot be represented as JSON: %w", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var ret any
+	if err := dec.Decode(&ret); err != nil {
+		return nil, fmt.Errorf("unable to decode document as JSON: %w", err)
+	}
+	return ret, nil
+}
+
+func stringKeys(v any) any {
+	switch t := v.(type) {
+	case map[string]any:
+		for k, val := range t {
+			t[k] = stringKeys(val)
+		}
+		return t
+	case map[any]any:
+		r := make(map[string]any, len(t))
+		for k, val := range t {
+			r[fmt.Sprint(k)] = stringKeys(val)
+		}
+		return r
+	case []any:
+		for i, val := range t {
+			t[i] = stringKeys(val)
+		}
+		return t
+	}
+	return v
+}
+
+func leafErrors(err *jsonschema.ValidationError) []*jsonschema.ValidationError {
+	if len(err.Causes) == 0 {
+		return []*jsonschema.ValidationError{err}
+	}
+	var ret []*jsonschema.ValidationError
+	for _, c := range err.Causes {
+		ret = append(ret, leafErrors(c)...)
+	}
+	return ret
+}
+
+func displayLocation(location string) string {
+	if location == "" {
+		return "document"
+	}
+	parts := strings.Split(strings.TrimPrefix(location, "/"), "/")
+	for i := range parts {
+		parts[i] = schemaLocationPart.Replace(parts[i])
+	}
+	return strings.Join(parts, ".")
+}
+
+// lineAt finds the line of the value at the JSON pointer location inside doc.  It returns the line of the deepest
+// node it can find.
+func lineAt(doc *yaml.Node, location string) int {
+	n := doc
+	if n.Kind == yaml.DocumentNode && len(n.Content) > 0 {
+		n = n.Content[0]
+	}
+	if location == "" {
+		return n.Line
+	}
+	for _, part := range strings.Split(strings.TrimPrefix(location, "/"), "/") {
+		part = schemaLocationPart.Replace(part)
+		if n.Kind == yaml.AliasNode && n.Alias != nil {
+			n = n.Alias
+		}
+		next := childNode(n, part)
+		if next == nil {
+			break
+		}
+		n = next
+	}
+	return n.Line
+}
+
+func childNode(n *yaml.Node, part string) *yaml.Node {
+	switch n.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if n.Content[i].Value == part {
+				return n.Content[i+1]
+			}
+		}
+	case yaml.SequenceNode:
+		if idx, err := strconv.Atoi(part); err == nil && idx >= 0 && idx < len(n.Content) {
+			return n.Content[idx]
+		}
+	}
+	return nil
+}
+
+// ValidateYAMLDir lints every YAML file in root that is tracked by git and validates files matching a glob of
+// Yq.Schemas against that schema.  Problems are printed as file:line and an error is returned if any were found.
+func (y *Yq) ValidateYAMLDir(ctx context.Context, root string) error {
+	yamlFiles, err := y.findYAML(ctx, root).All(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to read directory %s: %w", root, err)
+	}
+	compiled := make(map[string]*jsonschema.Schema)
+	var problems []Problem
+	for _, file := range yamlFiles {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		path := filepath.Join(root, file)
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("unable to read file %s: %w", path, err)
+		}
+		problems = append(problems, LintContent(path, content, y.Format)...)
+		for _, glob := range sortedKeys(y.Schemas) {
+			if !files.Match(glob, file) {
+				continue
+			}
+			location := y.Schemas[glob]
+			schema, exists := compiled[location]
+			if !exists {
+				schema, err = jsonschema.Compile(location)
+				if err != nil {
+					return fmt.Errorf("unable to compile schema %s: %w", location, err)
+				}
+				compiled[location] = schema
+			}
+			problems = append(problems, ValidateSchema(path, content, schema)...)
+		}
+	}
+	for _, p := range problems {
+		fmt.Println(p.String())
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("found %d problems in YAML files under %s", len(problems), root)
+	}
+	return nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	ret := make([]string, 0, len(m))
+	for k := range m {
+		ret = append(ret, k)
+	}
+	sort.Strings(ret)
+	return ret
+}
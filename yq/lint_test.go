@@ -0,0 +1,82 @@
+package yq
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cresta/magehelper/env"
+	"github.com/cresta/magehelper/pipe"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLintContent(t *testing.T) {
+	in := "a: 1\nb:\n    c: 1\na: 2\n"
+	require.Equal(t, []Problem{
+		{File: "x.yaml", Line: 3, Message: `wrong indentation under "b": expected 2 spaces but found 4`},
+		{File: "x.yaml", Line: 4, Message: `duplicate key "a", first defined on line 1`},
+	}, LintContent("x.yaml", []byte(in), FormatConfig{}))
+	require.Equal(t, []Problem{
+		{File: "x.yaml", Line: 4, Message: `duplicate key "a", first defined on line 1`},
+	}, LintContent("x.yaml", []byte(in), FormatConfig{Indent: 4}))
+}
+
+func TestLintContent_Lines(t *testing.T) {
+	in := "a: 1\n----\n---b: 1\n\tc: 1\n  \td: 1\n \t \n"
+	problems := LintContent("x.yaml", []byte(in), FormatConfig{})
+	require.Contains(t, problems, Problem{File: "x.yaml", Line: 5, Message: "tab character used for indentation"})
+	require.NotContains(t, problems, Problem{File: "x.yaml", Line: 6, Message: "tab character used for indentation"})
+	require.Contains(t, problems, Problem{File: "x.yaml", Line: 2, Message: `invalid document separator "----", expected ---`})
+	require.Contains(t, problems, Problem{File: "x.yaml", Line: 3, Message: "document separator must be followed by a space or the end of the line"})
+	require.Contains(t, problems, Problem{File: "x.yaml", Line: 4, Message: "tab character used for indentation"})
+}
+
+func TestLintContent_BlockScalars(t *testing.T) {
+	// Makefile lines in a heredoc need tabs, and text may look like a separator
+	in := "steps:\n- run: |\n    cat > Makefile <<EOF\n    all:\n    \techo hi\n\n    ----\n    EOF\n- name: x\n"
+	require.Empty(t, LintContent("x.yaml", []byte(in), FormatConfig{}))
+	problems := LintContent("x.yaml", []byte(in+"---\na: 1\n\tb: 1\n"), FormatConfig{})
+	require.Contains(t, problems, Problem{File: "x.yaml", Line: 12, Message: "tab character used for indentation"})
+	for _, p := range problems {
+		require.Greater(t, p.Line, 10, p)
+	}
+}
+
+func TestLintContent_Clean(t *testing.T) {
+	in := "a:\n  b: [1, 2]\n  c:\n  - x\n---\nd: |\n  text\n"
+	require.Empty(t, LintContent("x.yaml", []byte(in), FormatConfig{}))
+}
+
+func TestValidateSchema(t *testing.T) {
+	schema := jsonschema.MustCompileString("schema.json", `{
+		"type": "object",
+		"properties": {"jobs": {"type": "object", "additionalProperties": {"type": "object", "required": ["runs-on"]}}}
+	}`)
+	in := "name: x\njobs:\n  build:\n    steps: []\n---\njobs:\n  test:\n    runs-on: ubuntu-latest\n"
+	require.Equal(t, []Problem{
+		{File: "w.yml", Line: 4, Message: "jobs.build: missing properties: 'runs-on'"},
+	}, ValidateSchema("w.yml", []byte(in), schema))
+}
+
+func TestFindYAML_GitTracked(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(root, "tracked.yaml"), []byte("a: 1\n"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "untracked.yml"), []byte("a: 1\n"), 0o600))
+	y := Yq{Env: *env.NewFromMap(map[string]string{})}
+
+	// Outside a git repository every file that is not ignored is found
+	found, err := y.findYAML(ctx, root).All(ctx)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"tracked.yaml", "untracked.yml"}, found)
+
+	for _, args := range [][]string{{"init", "-q"}, {"add", "tracked.yaml"}} {
+		require.NoError(t, pipe.NewPiped("git", args...).WithDir(root).Execute(ctx, nil, io.Discard, io.Discard))
+	}
+	found, err = y.findYAML(ctx, root).All(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"tracked.yaml"}, found)
+}
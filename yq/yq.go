@@ -4,20 +4,109 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 
+	"github.com/cresta/magehelper/env"
 	"github.com/cresta/magehelper/files"
 	"github.com/cresta/magehelper/pipe"
+	"github.com/cresta/magehelper/tools"
+	"golang.org/x/sync/errgroup"
 )
 
-type Yq struct{}
+type Yq struct {
+	// Format configures the native formatter used by Reformat
+	Format FormatConfig
+	// UseCLI makes Reformat shell out to mikefarah yq v4 instead of formatting in process
+	UseCLI bool
+	// Schemas maps file globs, relative to the validated directory, to the JSON schema URL or path those files must
+	// match.  See SchemaStore for common ones.
+	Schemas map[string]string
+	// Concurrency is how many files the directory targets process at once.  Defaults to ${YQ_CONCURRENCY}, or 1
+	Concurrency int
+	Env         env.Env
+}
 
 var Instance Yq
 
+// YAMLExtensions are the file extensions the directory targets treat as YAML
+var YAMLExtensions = []string{".yaml", ".yml"}
+
+func (y *Yq) concurrency() int {
+	if y.Concurrency > 0 {
+		return y.Concurrency
+	}
+	if c, err := strconv.Atoi(y.Env.Get("YQ_CONCURRENCY")); err == nil && c > 0 {
+		return c
+	}
+	return 1
+}
+
+// findYAML finds the YAML files in root the directory targets process: the ones tracked by git, or the ones not ignored
+// by a .gitignore when root is not in a git repository
+func (y *Yq) findYAML(ctx context.Context, root string) *files.Finder {
+	finder := files.Find(root).Extensions(YAMLExtensions...).ChangedIfRequested(&y.Env)
+	if err := pipe.NewPiped("git", "rev-parse", "--is-inside-work-tree").WithDir(root).ReadOnly().Execute(ctx, nil, io.Discard, io.Discard); err != nil {
+		return finder.GitIgnore()
+	}
+	return finder.GitTracked()
+}
+
+// forEachYAMLFile calls fn with the path of every YAML file in root that findYAML finds, running up to concurrency()
+// calls at once.  Files are processed while the tree is still being walked.
+func (y *Yq) forEachYAMLFile(ctx context.Context, root string, fn func(ctx context.Context, path string) error) error {
+	eg, egCtx := errgroup.WithContext(ctx)
+	eg.SetLimit(y.concurrency())
+	for found := range y.findYAML(ctx, root).Stream(egCtx) {
+		if found.Err != nil {
+			eg.Go(func() error {
+				return fmt.Errorf("unable to read directory %s: %w", root, found.Err)
+			})
+			break
+		}
+		path := filepath.Join(root, found.Path)
+		eg.Go(func() error {
+			return fn(egCtx, path)
+		})
+	}
+	return eg.Wait()
+}
+
+// Reformat pretty prints the YAML file at path in place
 func (y *Yq) Reformat(ctx context.Context, path string) error {
+	if y.UseCLI {
+		return y.ReformatCLI(ctx, path)
+	}
+	input, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("unable to read file %s: %w", path, err)
+	}
+	output, err := Format(input, y.Format)
+	if err != nil {
+		return fmt.Errorf("unable to reformat %s: %w", path, err)
+	}
+	if bytes.Equal(input, output) {
+		return nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("unable to stat file %s: %w", path, err)
+	}
+	if err := os.WriteFile(path, output, info.Mode()); err != nil {
+		return fmt.Errorf("unable to write file %s: %w", path, err)
+	}
+	return nil
+}
+
+// ReformatCLI pretty prints the YAML file at path in place using the yq binary
+func (y *Yq) ReformatCLI(ctx context.Context, path string) error {
+	if err := tools.Require(ctx, "yq"); err != nil {
+		return err
+	}
 	err := pipe.NewPiped("yq", "-P", "-i", path).Run(ctx)
 	if err != nil {
 		return fmt.Errorf("unable to reformat %s: %w", path, err)
@@ -26,12 +115,14 @@ func (y *Yq) Reformat(ctx context.Context, path string) error {
 }
 
 func (y *Yq) VersionCheck(ctx context.Context) error {
-	var out bytes.Buffer
-	err := pipe.NewPiped("yq", "--version").Execute(ctx, nil, &out, nil)
+	if err := tools.Require(ctx, "yq"); err != nil {
+		return err
+	}
+	out, err := pipe.NewPiped("yq", "--version").ReadOnly().Output(ctx)
 	if err != nil {
 		return fmt.Errorf("unable to check version: %w", err)
 	}
-	version := strings.TrimSpace(out.String())
+	version := strings.TrimSpace(out)
 	rgx := `.*mikefarah.* version v?4\..*`
 	cmp := regexp.MustCompile(rgx)
 	if !cmp.MatchString(version) {
@@ -41,20 +132,12 @@ func (y *Yq) VersionCheck(ctx context.Context) error {
 }
 
 func (y *Yq) ReformatYAMLDir(ctx context.Context, root string) error {
-	if err := y.VersionCheck(ctx); err != nil {
-		return fmt.Errorf("the YQ commands require yq verions 4: %w", err)
-	}
-	yamlFiles, err := files.AllWithExtensionInDir(root, ".yaml")
-	if err != nil {
-		return fmt.Errorf("unable to read directory %s: %w", root, err)
-	}
-	for _, file := range yamlFiles {
-		err := y.Reformat(ctx, filepath.Join(root, file))
-		if err != nil {
-			return fmt.Errorf("unable to reformat %s: %w", file, err)
+	if y.UseCLI {
+		if err := y.VersionCheck(ctx); err != nil {
+			return fmt.Errorf("the YQ commands require yq verions 4: %w", err)
 		}
 	}
-	return nil
+	return y.forEachYAMLFile(ctx, root, y.Reformat)
 }
 
 func (y *Yq) TrimTrailingWhitespace(ctx context.Context, path string) error {
@@ -88,22 +171,15 @@ func (y *Yq) TrimTrailingWhitespace(ctx context.Context, path string) error {
 }
 
 func (y *Yq) TrimTrailingWhitespaceForYAMLDir(ctx context.Context, root string) error {
-	yamlFiles, err := files.AllWithExtensionInDir(root, ".yaml")
-	if err != nil {
-		return fmt.Errorf("unable to read directory %s: %w", root, err)
-	}
-
-	for _, file := range yamlFiles {
-		err := y.TrimTrailingWhitespace(ctx, filepath.Join(root, file))
-		if err != nil {
-			return fmt.Errorf("unable to trim trailing whitespace %s: %w", file, err)
+	return y.forEachYAMLFile(ctx, root, func(ctx context.Context, path string) error {
+		if err := y.TrimTrailingWhitespace(ctx, path); err != nil {
+			return fmt.Errorf("unable to trim trailing whitespace %s: %w", path, err)
 		}
-	}
-
-	return nil
+		return nil
+	})
 }
 
-// Reformat all YAML files in PATH with YQ
+// Reformat the YAML file at PATH
 func Reformat(ctx context.Context, path string) error {
 	return Instance.Reformat(ctx, path)
 }
@@ -113,16 +189,21 @@ func VersionCheck(ctx context.Context) error {
 	return Instance.VersionCheck(ctx)
 }
 
-// ReformatYAMLDir reformats all YAML files in PATH with YQ
+// ReformatYAMLDir reformats all YAML files in PATH that are tracked by git
 func ReformatYAMLDir(ctx context.Context, root string) error {
 	return Instance.ReformatYAMLDir(ctx, root)
 }
 
+// ValidateYAMLDir lints all YAML files in PATH and validates them against the configured schemas
+func ValidateYAMLDir(ctx context.Context, root string) error {
+	return Instance.ValidateYAMLDir(ctx, root)
+}
+
 func TrimTrailingWhitespace(ctx context.Context, path string) error {
 	return Instance.TrimTrailingWhitespace(ctx, path)
 }
 
-// TrimTrailingWhitespaceForYAMLDir trims trailing whitespace for all YAML files in PATH
+// TrimTrailingWhitespaceForYAMLDir trims trailing whitespace for all YAML files in PATH that are tracked by git
 func TrimTrailingWhitespaceForYAMLDir(ctx context.Context, root string) error {
 	return Instance.TrimTrailingWhitespaceForYAMLDir(ctx, root)
 }